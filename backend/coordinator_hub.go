@@ -0,0 +1,162 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+// coordinatorConn wraps one WebSocket connection registered with a
+// CoordinatorHub. send is that connection's single writer's inbox: the hub's
+// deliver, the initial state message, and timeSync replies all go through it
+// to writePump (which also sends the pings) instead of writing conn
+// directly, since gorilla/websocket
+// allows only one writer goroutine per connection - the same constraint
+// streaming_server's ClientConnection.send/writePump exists for.
+type coordinatorConn struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+// CoordinatorHub fans out one session's state updates, published to Redis
+// by whichever coordinator instance the host's WebSocket landed on, to this
+// process's local viewer connections for that session. It is the
+// coordinator-side counterpart to the streaming server's SessionHub, and is
+// what lets stateUpdate reach every viewer regardless of which instance
+// behind the load balancer they're connected to.
+type CoordinatorHub struct {
+	sessionKey string
+	clients    map[*coordinatorConn]bool
+	register   chan *coordinatorConn
+	unregister chan *coordinatorConn
+	pubsub     *redis.PubSub
+	done       chan struct{}
+}
+
+var (
+	coordinatorHubs   = make(map[string]*CoordinatorHub)
+	coordinatorHubsMu sync.Mutex
+)
+
+// getOrCreateCoordinatorHub returns the running hub for sessionKey, starting
+// one (and its Redis subscription) if this is the first local client for
+// that session.
+func getOrCreateCoordinatorHub(sessionKey string) *CoordinatorHub {
+	coordinatorHubsMu.Lock()
+	defer coordinatorHubsMu.Unlock()
+
+	if hub, exists := coordinatorHubs[sessionKey]; exists {
+		return hub
+	}
+
+	hub := &CoordinatorHub{
+		sessionKey: sessionKey,
+		clients:    make(map[*coordinatorConn]bool),
+		register:   make(chan *coordinatorConn),
+		unregister: make(chan *coordinatorConn),
+		pubsub:     rdb.Subscribe(ctx, sessionEventsChannel(sessionKey)),
+		done:       make(chan struct{}),
+	}
+	coordinatorHubs[sessionKey] = hub
+	go hub.run()
+	return hub
+}
+
+func (h *CoordinatorHub) run() {
+	redisCh := h.pubsub.Channel()
+	for {
+		select {
+		case c := <-h.register:
+			h.clients[c] = true
+
+		case c := <-h.unregister:
+			if _, ok := h.clients[c]; ok {
+				delete(h.clients, c)
+				close(c.send)
+			}
+			if len(h.clients) == 0 {
+				h.pubsub.Close()
+				coordinatorHubsMu.Lock()
+				delete(coordinatorHubs, h.sessionKey)
+				coordinatorHubsMu.Unlock()
+				return
+			}
+
+		case msg, ok := <-redisCh:
+			if !ok {
+				return
+			}
+			h.deliver([]byte(msg.Payload))
+
+		case <-h.done:
+			h.pubsub.Close()
+			return
+		}
+	}
+}
+
+func (h *CoordinatorHub) deliver(payload []byte) {
+	for c := range h.clients {
+		select {
+		case c.send <- payload:
+		default:
+			log.Printf("Dropping message to a client in session %s (send buffer full)", h.sessionKey)
+		}
+	}
+}
+
+// WebSocket connection tuning for coordinatorConn.writePump, mirroring the
+// ping/pong deadlines streaming_server's ClientConnection uses.
+const (
+	coordinatorWriteWait  = 10 * time.Second
+	coordinatorPingPeriod = 5 * time.Second
+)
+
+// writePump is the only goroutine allowed to write to c.conn: messages
+// queued by CoordinatorHub.deliver or handleTimeSync (via c.send) go out
+// here, interleaved with a ping every coordinatorPingPeriod, so no two
+// goroutines ever race on the same connection.
+func (c *coordinatorConn) writePump() {
+	ticker := time.NewTicker(coordinatorPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(coordinatorWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Printf("coordinator writePump error: %v", err)
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(coordinatorWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// drainCoordinatorHubs stops every hub's Redis subscription without closing
+// client connections itself, for use during graceful shutdown once the HTTP
+// server has stopped accepting new connections.
+func drainCoordinatorHubs() {
+	coordinatorHubsMu.Lock()
+	defer coordinatorHubsMu.Unlock()
+
+	for key, hub := range coordinatorHubs {
+		close(hub.done)
+		delete(coordinatorHubs, key)
+	}
+}
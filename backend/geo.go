@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// clientGeo derives the requesting client's country/continent for
+// country-aware streaming-server selection in validateSession. Either value
+// may come back empty, in which case AssignGeo falls through to the next,
+// wider selection tier.
+func clientGeo(r *http.Request) (country, continent string) {
+	return lookupGeo(clientIP(r))
+}
+
+// clientIP prefers X-Forwarded-For (the original client, first in the list)
+// and X-Real-IP over r.RemoteAddr, since both are commonly set by the
+// load balancer or CDN in front of this server.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	if real := r.Header.Get("X-Real-IP"); real != "" {
+		return real
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// geoRange is one row of the IP-range table loaded from GEOIP_RANGES_FILE.
+type geoRange struct {
+	network   *net.IPNet
+	country   string
+	continent string
+}
+
+var (
+	geoRangesOnce sync.Once
+	geoRanges     []geoRange
+)
+
+// lookupGeo resolves ip to a country/continent code against an optional
+// CIDR range table pointed to by GEOIP_RANGES_FILE, one "cidr,country,continent"
+// row per line (the same shape a MaxMind GeoLite2-Country CSV export can be
+// reduced to, so a real database can be dropped in without code changes).
+// If the env var isn't set, or ip matches no row, both values come back
+// empty and AssignGeo falls back to rendezvous hashing over every active node.
+func lookupGeo(ip string) (country, continent string) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", ""
+	}
+
+	for _, r := range geoRangeTable() {
+		if r.network.Contains(parsed) {
+			return r.country, r.continent
+		}
+	}
+	return "", ""
+}
+
+func geoRangeTable() []geoRange {
+	geoRangesOnce.Do(func() {
+		path := os.Getenv("GEOIP_RANGES_FILE")
+		if path == "" {
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Split(strings.TrimSpace(scanner.Text()), ",")
+			if len(fields) != 3 {
+				continue
+			}
+			_, network, err := net.ParseCIDR(fields[0])
+			if err != nil {
+				continue
+			}
+			geoRanges = append(geoRanges, geoRange{network: network, country: fields[1], continent: fields[2]})
+		}
+	})
+	return geoRanges
+}
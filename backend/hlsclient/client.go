@@ -0,0 +1,389 @@
+// Package hlsclient pulls an existing HLS stream into a session, mirroring
+// the approach (and tuned constants) of mediamtx's internal/hls/client.go:
+// fetch the master playlist, follow one variant's media playlist on a
+// pause, and download each new segment exactly once into a bounded queue.
+package hlsclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+const (
+	// segmentQueueSize bounds how many discovered-but-not-yet-downloaded
+	// segment URIs we hold in memory at once.
+	segmentQueueSize = 100
+
+	// minDownloadPause is the minimum time between consecutive media
+	// playlist refreshes, so we don't hammer the origin.
+	minDownloadPause = 5 * time.Second
+
+	// clientMinSegmentsBeforeDownloading is how many segments must appear
+	// in the media playlist before we start pulling any of them, to avoid
+	// racing the origin's own segment writer.
+	clientMinSegmentsBeforeDownloading = 3
+)
+
+// segment is one media-playlist entry queued for download, carrying enough
+// of the upstream EXTINF metadata to re-emit our own local playlist once
+// it lands on disk.
+type segment struct {
+	uri      string
+	duration float64
+}
+
+// Client pulls a remote HLS stream into sessionID's local hls/ directory.
+type Client struct {
+	sessionID  string
+	primaryURL string
+	outDir     string
+	segmentDir string
+	seen       map[string]bool
+	queue      chan segment
+	mediaURL   string
+	cancel     context.CancelFunc
+
+	// playlistMu guards downloaded, the ordered list of segments this
+	// client has actually fetched, used to rewrite playlist.m3u8 each
+	// time a new one lands (see writePlaylist).
+	playlistMu sync.Mutex
+	downloaded []segment
+	closed     bool
+}
+
+// New creates a puller for primaryURL, writing segments under
+// filepath.Join(hlsBaseDir, sessionID).
+func New(sessionID, primaryURL, hlsBaseDir string) *Client {
+	outDir := filepath.Join(hlsBaseDir, sessionID)
+	return &Client{
+		sessionID:  sessionID,
+		primaryURL: primaryURL,
+		outDir:     outDir,
+		segmentDir: filepath.Join(outDir, "segments"),
+		seen:       make(map[string]bool),
+		queue:      make(chan segment, segmentQueueSize),
+	}
+}
+
+// Start begins pulling in the background, under a context derived from ctx;
+// canceling ctx (or calling the Client's Stop, once Start has run) stops the
+// puller cleanly.
+func (c *Client) Start(ctx context.Context) error {
+	if err := os.MkdirAll(c.segmentDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	variantURL, err := c.resolvePrimaryVariant()
+	if err != nil {
+		return err
+	}
+	c.mediaURL = variantURL
+
+	runCtx, cancel := context.WithCancel(ctx)
+	c.cancel = cancel
+
+	go c.pollMediaPlaylist(runCtx)
+	go c.downloadLoop(runCtx)
+
+	return nil
+}
+
+// Stop cancels the puller; safe to call multiple times.
+func (c *Client) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// resolvePrimaryVariant fetches primaryURL, and if it's a master playlist,
+// picks the highest-bandwidth variant; if it's already a media playlist, it
+// is used as-is.
+func (c *Client) resolvePrimaryVariant() (string, error) {
+	resp, err := safeGet(c.primaryURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	playlist, listType, err := m3u8.DecodeFrom(resp.Body, true)
+	if err != nil {
+		return "", fmt.Errorf("decoding master playlist: %w", err)
+	}
+
+	if listType != m3u8.MASTER {
+		return c.primaryURL, nil
+	}
+
+	master := playlist.(*m3u8.MasterPlaylist)
+	if len(master.Variants) == 0 {
+		return "", fmt.Errorf("master playlist %s has no variants", c.primaryURL)
+	}
+
+	best := master.Variants[0]
+	for _, v := range master.Variants {
+		if v.Bandwidth > best.Bandwidth {
+			best = v
+		}
+	}
+
+	return resolveURL(c.primaryURL, best.URI)
+}
+
+// pollMediaPlaylist repeatedly re-fetches the media playlist, enqueueing any
+// segment URI it hasn't seen before, until #EXT-X-ENDLIST appears or ctx is
+// canceled.
+func (c *Client) pollMediaPlaylist(ctx context.Context) {
+	defer close(c.queue)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		closed, err := c.fetchAndEnqueue()
+		if err != nil {
+			log.Printf("hlsclient: session %s: error fetching media playlist: %v", c.sessionID, err)
+		}
+		if closed {
+			log.Printf("hlsclient: session %s: upstream reached EXT-X-ENDLIST, stopping", c.sessionID)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(minDownloadPause):
+		}
+	}
+}
+
+func (c *Client) fetchAndEnqueue() (closed bool, err error) {
+	resp, err := safeGet(c.mediaURL)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	playlist, listType, err := m3u8.DecodeFrom(resp.Body, true)
+	if err != nil {
+		return false, err
+	}
+	if listType != m3u8.MEDIA {
+		return false, fmt.Errorf("expected media playlist, got master")
+	}
+	media := playlist.(*m3u8.MediaPlaylist)
+
+	if len(c.seen) == 0 {
+		available := 0
+		for _, seg := range media.Segments {
+			if seg != nil && seg.URI != "" {
+				available++
+			}
+		}
+		if available < clientMinSegmentsBeforeDownloading {
+			return media.Closed, nil
+		}
+	}
+
+	newSegments := []segment{}
+	for _, seg := range media.Segments {
+		if seg == nil || seg.URI == "" || c.seen[seg.URI] {
+			continue
+		}
+		c.seen[seg.URI] = true
+		newSegments = append(newSegments, segment{uri: seg.URI, duration: seg.Duration})
+	}
+
+	for _, seg := range newSegments {
+		select {
+		case c.queue <- seg:
+		default:
+			log.Printf("hlsclient: session %s: segment queue full, dropping %s", c.sessionID, seg.uri)
+		}
+	}
+
+	return media.Closed, nil
+}
+
+// downloadLoop pulls segments off the queue, writes each one into the local
+// hls/<sessionID>/segments layout that serveHLSSegment reads from, and
+// rewrites playlist.m3u8 to include it so serveHLSMediaPlaylist has
+// something to serve. The queue is closed (by pollMediaPlaylist) once the
+// upstream playlist reaches EXT-X-ENDLIST or ctx is canceled; when this loop
+// sees the queue closed, it marks our own playlist closed too. If it sees
+// ctx canceled first, it returns without marking the playlist closed.
+func (c *Client) downloadLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case seg, ok := <-c.queue:
+			if !ok {
+				c.markClosed()
+				return
+			}
+			if err := c.downloadSegment(seg.uri); err != nil {
+				log.Printf("hlsclient: session %s: error downloading segment %s: %v", c.sessionID, seg.uri, err)
+				continue
+			}
+			c.appendDownloaded(seg)
+		}
+	}
+}
+
+func (c *Client) downloadSegment(uri string) error {
+	segmentURL, err := resolveURL(c.mediaURL, uri)
+	if err != nil {
+		return err
+	}
+
+	resp, err := safeGet(segmentURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dstPath := filepath.Join(c.segmentDir, path.Base(uri))
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, resp.Body)
+	return err
+}
+
+// appendDownloaded records seg as landed and rewrites playlist.m3u8 to
+// include it.
+func (c *Client) appendDownloaded(seg segment) {
+	c.playlistMu.Lock()
+	c.downloaded = append(c.downloaded, seg)
+	downloaded := append([]segment(nil), c.downloaded...)
+	closed := c.closed
+	c.playlistMu.Unlock()
+
+	if err := c.writePlaylist(downloaded, closed); err != nil {
+		log.Printf("hlsclient: session %s: error writing playlist: %v", c.sessionID, err)
+	}
+}
+
+// markClosed flags the local playlist as complete (EXT-X-ENDLIST) and
+// rewrites it once more to record that.
+func (c *Client) markClosed() {
+	c.playlistMu.Lock()
+	c.closed = true
+	downloaded := append([]segment(nil), c.downloaded...)
+	c.playlistMu.Unlock()
+
+	if err := c.writePlaylist(downloaded, true); err != nil {
+		log.Printf("hlsclient: session %s: error writing closing playlist: %v", c.sessionID, err)
+	}
+}
+
+// writePlaylist rewrites playlist.m3u8 with one EXTINF entry per downloaded
+// segment, pointing at the local "segments/<name>" path serveHLSSegment
+// reads from.
+func (c *Client) writePlaylist(downloaded []segment, closed bool) error {
+	targetDuration := 1
+	for _, seg := range downloaded {
+		if d := int(seg.duration + 0.5); d > targetDuration {
+			targetDuration = d
+		}
+	}
+
+	var body strings.Builder
+	body.WriteString("#EXTM3U\n")
+	body.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&body, "#EXT-X-TARGETDURATION:%d\n", targetDuration)
+	body.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	for _, seg := range downloaded {
+		fmt.Fprintf(&body, "#EXTINF:%.3f,\nsegments/%s\n", seg.duration, path.Base(seg.uri))
+	}
+	if closed {
+		body.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	return os.WriteFile(filepath.Join(c.outDir, "playlist.m3u8"), []byte(body.String()), 0o644)
+}
+
+func resolveURL(base, ref string) (string, error) {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return ref, nil
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
+
+// safeGet is http.Get with ValidateURL applied first: every fetch this
+// client makes - the caller-supplied primaryURL as well as media/segment
+// URLs later resolved out of playlists we don't control - goes through
+// here, so a malicious or compromised upstream can't redirect us at the
+// playlist level into probing internal-only hosts.
+func safeGet(rawURL string) (*http.Response, error) {
+	if err := ValidateURL(rawURL); err != nil {
+		return nil, err
+	}
+	return http.Get(rawURL)
+}
+
+// ValidateURL rejects URLs that would let this client be used for SSRF:
+// only plain http/https is allowed, and the hostname must not resolve to
+// a loopback, private, or link-local address (cloud metadata endpoints,
+// Redis, and other internal-only hosts all live in these ranges).
+// Exported so callers starting a Client - namely handlePullIngest - can
+// reject an obviously-bad URL up front instead of only finding out once
+// Start's first fetch fails.
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("parsing URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("URL has no host")
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("resolving host %q: %w", host, err)
+	}
+	for _, ip := range ips {
+		if isDisallowedIP(ip) {
+			return fmt.Errorf("host %q resolves to disallowed address %s", host, ip)
+		}
+	}
+	return nil
+}
+
+// isDisallowedIP reports whether ip falls in a range that has no business
+// being the target of a server-side fetch: loopback, RFC 1918/4193
+// private space, or link-local (unicast or multicast).
+func isDisallowedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -14,6 +18,8 @@ import (
 	"github.com/gorilla/handlers" // For CORS
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+
+	"videosync/backend/serverpool"
 )
 
 type Config struct {
@@ -45,19 +51,13 @@ var (
 		WriteBufferSize: 1024,
 		CheckOrigin:     func(r *http.Request) bool { return true },
 	}
-	connections = struct {
-		sync.Mutex
-		m map[string][]*websocket.Conn
-	}{m: make(map[string][]*websocket.Conn)}
 )
 
-var (
-	streamingServers = make(map[string]*StreamingServer)
-	serverMutex      sync.RWMutex
-)
+var pool = serverpool.NewPool(3 * heartbeatInterval)
 
 const (
-	sessionExpiry = time.Hour * 24
+	sessionExpiry     = time.Hour * 24
+	heartbeatInterval = 30 * time.Second
 )
 
 type SessionState struct {
@@ -74,6 +74,8 @@ type StreamingServer struct {
 	Status      string    `json:"status"`
 	LastPing    int64     `json:"lastPing"`
 	Registered  time.Time `json:"registered"`
+	Country     string    `json:"country"`
+	Continent   string    `json:"continent"`
 }
 
 func main() {
@@ -134,8 +136,12 @@ func main() {
 	r.HandleFunc("/ws", handleWebSocket)
 	r.HandleFunc("/api/sessions", createSession).Methods("POST")
 	r.HandleFunc("/api/sessions/{key}/validate", validateSession).Methods("GET")
+	r.HandleFunc("/api/sessions/{key}/subscribe", handleSessionSubscribe).Methods("GET")
+	r.HandleFunc("/api/sessions/{key}/manifest.m3u8", handleSessionManifest).Methods("GET")
+	r.HandleFunc("/api/sessions/{key}/manifest.mpd", handleSessionManifestDASH).Methods("GET")
 	r.HandleFunc("/api/streaming-servers/register", registerStreamingServer).Methods("POST")
 	r.HandleFunc("/api/streaming-servers/heartbeat", handleHeartbeat).Methods("POST")
+	r.HandleFunc("/api/streaming-servers/assign", handleAssignServer).Methods("GET")
 
 	// Start server
 	log.Println("Starting server on :8080")
@@ -153,10 +159,41 @@ func main() {
 	exposedOk := handlers.ExposedHeaders([]string{"Content-Length"})
 
 	// Start background tasks
-	go cleanupInactiveServers()
+	go pool.StartEvictionLoop(time.Minute)
 
-	log.Fatal(http.ListenAndServe("0.0.0.0:8080",
-		handlers.CORS(originsOk, headersOk, methodsOk, exposedOk)(r)))
+	srv := &http.Server{
+		Addr:    "0.0.0.0:8080",
+		Handler: handlers.CORS(originsOk, headersOk, methodsOk, exposedOk)(r),
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("ListenAndServe: %v", err)
+		}
+	}()
+
+	gracefulShutdown(srv)
+}
+
+// gracefulShutdown blocks until SIGINT/SIGTERM, then stops the HTTP server
+// from accepting new connections and drains every CoordinatorHub's Redis
+// subscription before returning, so a redeploy doesn't leave subscriber
+// goroutines (and their Redis connections) leaked.
+func gracefulShutdown(srv *http.Server) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
+	}
+
+	drainCoordinatorHubs()
+	log.Println("Shutdown complete")
 }
 
 // Session creation endpoint
@@ -249,19 +286,18 @@ func validateSession(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Get streaming server for the session
-	server := getLeastLoadedServer()
-	if server == nil {
+	// Get the streaming server closest to this client: same country first,
+	// then same continent, then any active node, with the session pinned to
+	// one node of that tier by rendezvous hashing.
+	clientCountry, clientContinent := clientGeo(r)
+	server, ok := pool.AssignGeo(sessionKey, clientCountry, clientContinent)
+	if !ok {
 		log.Printf("No streaming servers available for session: %s", sessionKey)
 		respondError(w, http.StatusServiceUnavailable, "no_streaming_servers_available")
 		return
 	}
 
-	serverURL := server.URL
-	if !strings.HasPrefix(serverURL, "http") {
-		serverURL = "http://" + serverURL
-	}
-	serverURL = strings.TrimSuffix(serverURL, "/")
+	serverURL := normalizeServerURL(server.URL)
 
 	log.Printf("Session validated - Key: %s, Is Host: %v, Server: %s", sessionKey, isHost, server.ID)
 
@@ -279,16 +315,13 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		log.Println("WebSocket upgrade error:", err)
 		return
 	}
-	defer func() {
-		conn.Close()
-		cleanupConnection(conn)
-	}()
 
 	// Get session key from query params
 	sessionKey := r.URL.Query().Get("sessionKey")
 	if sessionKey == "" {
 		conn.WriteMessage(websocket.CloseMessage,
 			websocket.FormatCloseMessage(4001, "Missing session key"))
+		conn.Close()
 		return
 	}
 
@@ -298,9 +331,19 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	if err != nil || exists == 0 {
 		conn.WriteMessage(websocket.CloseMessage,
 			websocket.FormatCloseMessage(4001, "Invalid session key"))
+		conn.Close()
 		return
 	}
 
+	cc := &coordinatorConn{conn: conn, send: make(chan []byte, 16)}
+	hub := getOrCreateCoordinatorHub(sessionKey)
+	hub.register <- cc
+	go cc.writePump()
+	defer func() {
+		hub.unregister <- cc
+		conn.Close()
+	}()
+
 	// Determine host status
 	isHost := false
 	hostKey := "session:" + sessionKey + ":host"
@@ -309,10 +352,14 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		log.Printf("New host connected to session: %s", sessionKey)
 	}
 
-	// Register connection
-	connections.Lock()
-	connections.m[sessionKey] = append(connections.m[sessionKey], conn)
-	connections.Unlock()
+	// Keep the host-election lock from expiring out from under a connected
+	// host; a renewal loop instead of a one-shot SetNX TTL means the lock
+	// only lapses once the host actually disconnects.
+	var hostLockDone chan struct{}
+	if isHost {
+		hostLockDone = make(chan struct{})
+		go renewHostLock(hostKey, hostLockDone)
+	}
 
 	// Send initial state
 	stateJson, err := rdb.Get(ctx, "session:"+sessionKey+":state").Bytes()
@@ -332,14 +379,16 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		"timestamp": time.Now().UnixMilli(),
 	}
 
-	if err := conn.WriteJSON(initialMessage); err != nil {
-		log.Printf("Error sending initial message: %v", err)
+	initialPayload, err := json.Marshal(initialMessage)
+	if err != nil {
+		log.Printf("Error marshaling initial message: %v", err)
 		return
 	}
-
-	// Start heartbeat
-	done := make(chan struct{})
-	go heartbeatRoutine(conn, done)
+	select {
+	case cc.send <- initialPayload:
+	default:
+		log.Printf("Dropping initial message to client in session %s (send buffer full)", sessionKey)
+	}
 
 	// Message handling loop
 	for {
@@ -353,70 +402,82 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
-		if isHost {
-			var msg struct {
-				Type      string       `json:"type"`
-				State     SessionState `json:"state"`
-				Timestamp int64        `json:"timestamp"`
+		var envelope struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(message, &envelope); err != nil {
+			log.Printf("Error decoding message: %v", err)
+			continue
+		}
+
+		switch envelope.Type {
+		case "timeSync":
+			handleTimeSync(cc, message)
+
+		case "stateUpdate":
+			if !isHost {
+				continue
 			}
 
+			var msg struct {
+				State SessionState `json:"state"`
+			}
 			if err := json.Unmarshal(message, &msg); err != nil {
 				log.Printf("Error decoding message: %v", err)
 				continue
 			}
 
-			if msg.Type == "stateUpdate" {
-				// Update state in Redis with timestamp
-				stateWithTs := struct {
-					SessionState
-					Timestamp int64 `json:"timestamp"`
-				}{
-					SessionState: msg.State,
-					Timestamp:    time.Now().UnixMilli(),
-				}
-
-				stateJson, _ := json.Marshal(stateWithTs)
+			// Update state in Redis with timestamp
+			stateWithTs := struct {
+				SessionState
+				Timestamp int64 `json:"timestamp"`
+			}{
+				SessionState: msg.State,
+				Timestamp:    time.Now().UnixMilli(),
+			}
 
-				// Store in Redis with extended expiration
-				err := rdb.SetEX(ctx,
-					"session:"+sessionKey+":state",
-					stateJson,
-					sessionExpiry,
-				).Err()
+			stateJson, _ := json.Marshal(stateWithTs)
 
-				if err != nil {
-					log.Printf("Error saving state: %v", err)
-					continue
-				}
+			// Store in Redis with extended expiration
+			err := rdb.SetEX(ctx,
+				"session:"+sessionKey+":state",
+				stateJson,
+				sessionExpiry,
+			).Err()
 
-				// Broadcast to all clients in session
-				broadcastState(sessionKey, stateJson)
+			if err != nil {
+				log.Printf("Error saving state: %v", err)
+				continue
 			}
+
+			// Broadcast to all clients in session
+			broadcastState(sessionKey, stateJson)
 		}
 	}
 
 	// Cleanup if host disconnects
 	if isHost {
+		close(hostLockDone)
 		if err := rdb.Del(ctx, hostKey).Err(); err != nil {
 			log.Printf("Error deleting host key: %v", err)
 		}
 		log.Printf("Host disconnected from session: %s", sessionKey)
 	}
-
-	close(done)
 }
 
-func heartbeatRoutine(conn *websocket.Conn, done <-chan struct{}) {
-	ticker := time.NewTicker(5 * time.Second)
+// renewHostLock keeps hostKey's TTL from lapsing while its host stays
+// connected, refreshing at half the session expiry so the TTL is renewed
+// well before it can lapse and let another connection win the host election
+// out from under it.
+func renewHostLock(hostKey string, done <-chan struct{}) {
+	ticker := time.NewTicker(sessionExpiry / 2)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("Heartbeat failed: %v", err)
-				return
+			if err := rdb.Expire(ctx, hostKey, sessionExpiry).Err(); err != nil {
+				log.Printf("Error renewing host lock %s: %v", hostKey, err)
 			}
 		case <-done:
 			return
@@ -424,58 +485,83 @@ func heartbeatRoutine(conn *websocket.Conn, done <-chan struct{}) {
 	}
 }
 
-func broadcastState(sessionKey string, state []byte) {
-	connections.Lock()
-	defer connections.Unlock()
-
-	clients, exists := connections.m[sessionKey]
-	if !exists {
+// handleTimeSync answers a client's {type:"timeSync", t0} with the server's
+// receive and send times (t1, t2), so the client can compute its RTT and
+// clock offset relative to the server once it also knows its own receive
+// time (t3, which never leaves the client):
+//
+//	offset = ((t1 - t0) + (t2 - t3)) / 2
+//	rtt    = (t3 - t0) - (t2 - t1)
+func handleTimeSync(cc *coordinatorConn, message []byte) {
+	var req struct {
+		T0 int64 `json:"t0"`
+	}
+	if err := json.Unmarshal(message, &req); err != nil {
+		log.Printf("Error decoding timeSync: %v", err)
 		return
 	}
 
-	// Prepare message with server timestamp
-	msg := struct {
-		Type      string          `json:"type"`
-		State     json.RawMessage `json:"state"`
-		Timestamp int64           `json:"timestamp"`
-	}{
-		Type:      "stateUpdate",
-		State:     state,
-		Timestamp: time.Now().UnixMilli(),
+	resp := map[string]interface{}{
+		"type": "timeSync",
+		"t0":   req.T0,
+		"t1":   time.Now().UnixMilli(),
+		"t2":   time.Now().UnixMilli(),
 	}
 
-	msgBytes, _ := json.Marshal(msg)
+	payload, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("Error marshaling timeSync reply: %v", err)
+		return
+	}
 
-	// Broadcast to all clients in session
-	for _, client := range clients {
-		if client != nil {
-			go func(c *websocket.Conn) {
-				c.SetWriteDeadline(time.Now().Add(2 * time.Second))
-				if err := c.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
-					log.Printf("Broadcast error: %v", err)
-				}
-			}(client)
-		}
+	select {
+	case cc.send <- payload:
+	default:
+		log.Printf("Dropping timeSync reply (send buffer full)")
 	}
 }
 
-func cleanupConnection(conn *websocket.Conn) {
-	connections.Lock()
-	defer connections.Unlock()
+// sessionEventsChannel is the Redis Pub/Sub channel state updates for
+// sessionKey are published to, so subscribers other than this process's own
+// WebSocket clients - namely SSE viewers via handleSessionSubscribe - also
+// see every update.
+func sessionEventsChannel(sessionKey string) string {
+	return "session:" + sessionKey + ":events"
+}
 
-	for sessionKey, clients := range connections.m {
-		for i, client := range clients {
-			if client == conn {
-				// Remove connection from slice
-				connections.m[sessionKey] = append(clients[:i], clients[i+1:]...)
+// broadcastState publishes a session's new state to Redis, wrapped the same
+// way local WebSocket clients expect it. Every coordinator instance with a
+// CoordinatorHub running for sessionKey - not just the one the host's
+// connection landed on - delivers it to its own local clients from there.
+// broadcastSeq is a monotonic counter stamped onto every published
+// stateUpdate as Seq, so a client that receives messages out of order (e.g.
+// racing SSE and WebSocket delivery, or Redis fan-out across coordinator
+// instances) can tell which one is newest and drop the rest.
+var broadcastSeq int64
 
-				// Remove session if empty
-				if len(connections.m[sessionKey]) == 0 {
-					delete(connections.m, sessionKey)
-				}
-				return
-			}
-		}
+func broadcastState(sessionKey string, state []byte) {
+	msg := struct {
+		Type         string          `json:"type"`
+		State        json.RawMessage `json:"state"`
+		Timestamp    int64           `json:"timestamp"`
+		ServerSendMs int64           `json:"serverSendMs"`
+		Seq          int64           `json:"seq"`
+	}{
+		Type:         "stateUpdate",
+		State:        state,
+		Timestamp:    time.Now().UnixMilli(),
+		ServerSendMs: time.Now().UnixMilli(),
+		Seq:          atomic.AddInt64(&broadcastSeq, 1),
+	}
+
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling state update: %v", err)
+		return
+	}
+
+	if err := rdb.Publish(ctx, sessionEventsChannel(sessionKey), msgBytes).Err(); err != nil {
+		log.Printf("Error publishing state update: %v", err)
 	}
 }
 
@@ -486,12 +572,20 @@ func registerStreamingServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	server.Registered = time.Now()
-	server.LastPing = time.Now().Unix()
+	status := server.Status
+	if status == "" {
+		status = serverpool.StatusActive
+	}
 
-	serverMutex.Lock()
-	streamingServers[server.ID] = &server
-	serverMutex.Unlock()
+	pool.Register(serverpool.Node{
+		ID:          server.ID,
+		URL:         server.URL,
+		Capacity:    server.Capacity,
+		CurrentLoad: server.CurrentLoad,
+		Status:      status,
+		Country:     server.Country,
+		Continent:   server.Continent,
+	})
 
 	log.Printf("Registered streaming server: %s", server.ID)
 	w.WriteHeader(http.StatusOK)
@@ -504,51 +598,54 @@ func handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	serverMutex.Lock()
-	if existingServer, exists := streamingServers[server.ID]; exists {
-		existingServer.CurrentLoad = server.CurrentLoad
-		existingServer.LastPing = time.Now().Unix()
-		existingServer.Status = "active"
+	status := server.Status
+	if status == "" {
+		status = serverpool.StatusActive
 	}
-	serverMutex.Unlock()
+	pool.Heartbeat(server.ID, server.CurrentLoad, status)
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func getLeastLoadedServer() *StreamingServer {
-	serverMutex.RLock()
-	defer serverMutex.RUnlock()
+// handleAssignServer looks up the streaming server that should serve
+// sessionID, without touching session state in Redis - used by clients that
+// already validated a session and just need to re-resolve their server (e.g.
+// after a reconnect).
+func handleAssignServer(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("sessionID")
+	if sessionID == "" {
+		respondError(w, http.StatusBadRequest, "missing_session_id")
+		return
+	}
 
-	var bestServer *StreamingServer
-	lowestLoad := float64(1.0)
+	// strategy defaults to rendezvous (session stickiness); callers that
+	// want a different tradeoff - e.g. least_loaded or power_of_two - can
+	// opt in per request instead of changing serverpool.DefaultStrategy.
+	strategy := serverpool.Strategy(r.URL.Query().Get("strategy"))
+	if strategy == "" {
+		strategy = serverpool.StrategyRendezvous
+	}
 
-	for _, server := range streamingServers {
-		if server.Status != "active" {
-			continue
-		}
-		load := float64(server.CurrentLoad) / float64(server.Capacity)
-		if load < lowestLoad {
-			lowestLoad = load
-			bestServer = server
-		}
+	server, ok := pool.Pick(strategy, sessionID)
+	if !ok {
+		respondError(w, http.StatusServiceUnavailable, "no_streaming_servers_available")
+		return
 	}
 
-	return bestServer
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"serverID": server.ID,
+		"url":      normalizeServerURL(server.URL),
+	})
 }
 
-func cleanupInactiveServers() {
-	ticker := time.NewTicker(1 * time.Minute)
-	for range ticker.C {
-		serverMutex.Lock()
-		now := time.Now().Unix()
-		for id, server := range streamingServers {
-			if now-server.LastPing > 60 { // Remove servers inactive for more than 1 minute
-				delete(streamingServers, id)
-				log.Printf("Removed inactive streaming server: %s", id)
-			}
-		}
-		serverMutex.Unlock()
+// normalizeServerURL makes a registered server URL safe to hand to a client:
+// it adds a scheme if the server registered a bare host:port, and drops any
+// trailing slash so callers can append paths directly.
+func normalizeServerURL(serverURL string) string {
+	if !strings.HasPrefix(serverURL, "http") {
+		serverURL = "http://" + serverURL
 	}
+	return strings.TrimSuffix(serverURL, "/")
 }
 
 func respondJSON(w http.ResponseWriter, statusCode int, data interface{}) {
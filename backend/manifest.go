@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"videosync/backend/viewertoken"
+
+	"github.com/grafov/m3u8"
+)
+
+// viewerTokenTTL is how long a minted viewer token authorizes segment
+// access for, starting from when the manifest was issued.
+const viewerTokenTTL = 6 * time.Hour
+
+// handleSessionManifest picks the streaming server assigned to sessionKey,
+// fetches its master.m3u8, and rewrites every variant URI to an absolute
+// URL on that server carrying a signed, per-viewer token - replacing the
+// raw streaming_url validateSession used to return with a manifest the
+// streaming server can authorize per request.
+func handleSessionManifest(w http.ResponseWriter, r *http.Request) {
+	sessionKey := mux.Vars(r)["key"]
+
+	exists, err := rdb.Exists(ctx, "session:"+sessionKey).Result()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "internal_server_error")
+		return
+	}
+	if exists == 0 {
+		respondError(w, http.StatusNotFound, "session_not_found")
+		return
+	}
+
+	server, ok := pool.Assign(sessionKey)
+	if !ok {
+		respondError(w, http.StatusServiceUnavailable, "no_streaming_servers_available")
+		return
+	}
+	serverURL := normalizeServerURL(server.URL)
+
+	viewerID := r.URL.Query().Get("viewerID")
+	if viewerID == "" {
+		viewerID = uuid.New().String()
+	}
+	expiry := time.Now().Add(viewerTokenTTL).Unix()
+	token := viewertoken.Mint(sessionKey, viewerID, expiry)
+
+	resp, err := http.Get(fmt.Sprintf("%s/hls/%s/master.m3u8", serverURL, sessionKey))
+	if err != nil {
+		log.Printf("Error fetching master playlist for session %s: %v", sessionKey, err)
+		respondError(w, http.StatusBadGateway, "origin_unreachable")
+		return
+	}
+	defer resp.Body.Close()
+
+	playlist, listType, err := m3u8.DecodeFrom(resp.Body, true)
+	if err != nil || listType != m3u8.MASTER {
+		log.Printf("Error decoding master playlist for session %s: %v", sessionKey, err)
+		respondError(w, http.StatusBadGateway, "invalid_origin_manifest")
+		return
+	}
+	master := playlist.(*m3u8.MasterPlaylist)
+
+	for _, variant := range master.Variants {
+		signedURL, err := signVariantURL(serverURL, sessionKey, variant.URI, viewerID, expiry, token)
+		if err != nil {
+			log.Printf("Error signing variant URL %q: %v", variant.URI, err)
+			continue
+		}
+		variant.URI = signedURL
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write(master.Encode().Bytes())
+}
+
+// handleSessionManifestDASH would serve a DASH (.mpd) equivalent, but the
+// transcoder only ever produces an HLS ladder - there's no MPD to rewrite.
+func handleSessionManifestDASH(w http.ResponseWriter, r *http.Request) {
+	respondError(w, http.StatusNotImplemented, "dash_not_supported")
+}
+
+func signVariantURL(serverURL, sessionKey, variantURI, viewerID string, expiry int64, token string) (string, error) {
+	u, err := url.Parse(serverURL + "/hls/" + sessionKey + "/" + variantURI)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("viewerID", viewerID)
+	q.Set("expiry", fmt.Sprintf("%d", expiry))
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
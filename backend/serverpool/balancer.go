@@ -0,0 +1,97 @@
+package serverpool
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Strategy selects which algorithm Pick uses to choose among active nodes.
+type Strategy string
+
+const (
+	// StrategyLeastLoaded always picks the lowest CurrentLoad/Capacity
+	// ratio. Simple and even, but every coordinator instance converges on
+	// the same node when many sessions start at once.
+	StrategyLeastLoaded Strategy = "least_loaded"
+
+	// StrategyPowerOfTwo samples two random active nodes and picks the less
+	// loaded of the pair, which avoids that herding effect almost as well
+	// as checking every node, at O(1) cost instead of O(n).
+	StrategyPowerOfTwo Strategy = "power_of_two"
+
+	// StrategyRendezvous hashes key against every active node's ID,
+	// weighted by Capacity, and picks the max - so the same key always
+	// lands on the same node (until that node leaves the pool) without a
+	// central key->node table.
+	StrategyRendezvous Strategy = "rendezvous"
+)
+
+// DefaultStrategy is used by Pick when the caller doesn't specify one.
+var DefaultStrategy Strategy = StrategyLeastLoaded
+
+// Pick chooses an active node using strategy (or DefaultStrategy if
+// strategy is empty). key is only consulted by StrategyRendezvous, where it
+// determines which node a given session sticks to.
+func (p *Pool) Pick(strategy Strategy, key string) (*Node, bool) {
+	active := p.Active()
+	if len(active) == 0 {
+		return nil, false
+	}
+
+	if strategy == "" {
+		strategy = DefaultStrategy
+	}
+
+	switch strategy {
+	case StrategyPowerOfTwo:
+		return powerOfTwo(active), true
+	case StrategyRendezvous:
+		return weightedRendezvous(active, key), true
+	default:
+		return bestByRatio(active), true
+	}
+}
+
+func powerOfTwo(active []*Node) *Node {
+	if len(active) == 1 {
+		return active[0]
+	}
+	a := active[rand.Intn(len(active))]
+	b := active[rand.Intn(len(active))]
+	if ratio(b) < ratio(a) {
+		return b
+	}
+	return a
+}
+
+// weightedRendezvous is HRW hashing with each node's score scaled by its
+// Capacity, so higher-capacity nodes win proportionally more keys without
+// needing a ring rebuild when the active set changes.
+func weightedRendezvous(active []*Node, key string) *Node {
+	var best *Node
+	var bestScore float64
+	for _, n := range active {
+		score := weightedRendezvousScore(key, n)
+		if best == nil || score > bestScore {
+			best = n
+			bestScore = score
+		}
+	}
+	return best
+}
+
+func weightedRendezvousScore(key string, n *Node) float64 {
+	weight := n.Capacity
+	if weight <= 0 {
+		weight = 1
+	}
+
+	// Normalize the hash to (0, 1) and feed it through the standard HRW
+	// weighting formula (-weight / ln(x)) so capacity scales influence
+	// without distorting the underlying hash distribution.
+	x := float64(rendezvousScore(key, n.ID)) / float64(math.MaxUint64)
+	if x <= 0 {
+		x = math.SmallestNonzeroFloat64
+	}
+	return -float64(weight) / math.Log(x)
+}
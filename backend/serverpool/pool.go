@@ -0,0 +1,194 @@
+// Package serverpool tracks the live streaming-server fleet the main server
+// load-balances across: registration, heartbeats, TTL eviction, and
+// rendezvous-hashed session assignment so reassignments on node loss stay
+// minimal.
+package serverpool
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	StatusActive   = "active"
+	StatusDraining = "draining"
+)
+
+// Node is one registered streaming server.
+type Node struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Capacity    int       `json:"capacity"`
+	CurrentLoad int       `json:"currentLoad"`
+	Status      string    `json:"status"`
+	LastPing    int64     `json:"lastPing"`
+	Registered  time.Time `json:"registered"`
+
+	// Country/Continent are reported at registration time and used by
+	// AssignGeo to prefer nearby nodes. Empty if the node didn't report one.
+	Country   string `json:"country"`
+	Continent string `json:"continent"`
+}
+
+// Pool is the live set of streaming-server nodes. The zero value is not
+// usable; construct with NewPool.
+type Pool struct {
+	mu    sync.RWMutex
+	nodes map[string]*Node
+	ttl   time.Duration
+}
+
+// NewPool builds a Pool that evicts nodes once their last heartbeat is
+// older than ttl (the caller should pass 3x its heartbeat interval).
+func NewPool(ttl time.Duration) *Pool {
+	return &Pool{
+		nodes: make(map[string]*Node),
+		ttl:   ttl,
+	}
+}
+
+// Register adds or replaces a node, as reported by /api/streaming-servers/register.
+func (p *Pool) Register(n Node) {
+	n.Registered = time.Now()
+	n.LastPing = time.Now().Unix()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nodes[n.ID] = &n
+}
+
+// Heartbeat updates a known node's load and status; unknown node IDs are
+// ignored (the node should register first).
+func (p *Pool) Heartbeat(id string, load int, status string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, exists := p.nodes[id]
+	if !exists {
+		return
+	}
+	node.CurrentLoad = load
+	node.LastPing = time.Now().Unix()
+	if status != "" {
+		node.Status = status
+	}
+}
+
+// Active returns a snapshot of every node whose status is "active".
+func (p *Pool) Active() []*Node {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	active := make([]*Node, 0, len(p.nodes))
+	for _, n := range p.nodes {
+		if n.Status == StatusActive {
+			copy := *n
+			active = append(active, &copy)
+		}
+	}
+	return active
+}
+
+// Assign picks the node that should serve sessionID via weighted rendezvous
+// (HRW) hashing over the currently active nodes: all clients for one
+// session land on the same node without a central session->node table, and
+// losing a node only reassigns the sessions it was uniquely the max for.
+// It's a thin wrapper over Pick(StrategyRendezvous, sessionID).
+func (p *Pool) Assign(sessionID string) (*Node, bool) {
+	return p.Pick(StrategyRendezvous, sessionID)
+}
+
+// LeastLoaded picks the active node with the lowest CurrentLoad/Capacity
+// ratio. It's a thin wrapper over Pick(StrategyLeastLoaded, "").
+func (p *Pool) LeastLoaded() (*Node, bool) {
+	return p.Pick(StrategyLeastLoaded, "")
+}
+
+// AssignGeo picks the node closest to a client in clientCountry for
+// sessionID: an exact country match first, falling back to clientContinent,
+// then to the whole active set. Within whichever tier has candidates, it
+// sticks sessionID to one node via weighted rendezvous hashing (same as
+// Assign), so repeated calls for the same session - from a reconnect, or a
+// different viewer joining later - keep landing on the node that actually
+// has that session's transcoded output, instead of drifting to whichever
+// node is least loaded at the moment.
+func (p *Pool) AssignGeo(sessionID, clientCountry, clientContinent string) (*Node, bool) {
+	active := p.Active()
+	if len(active) == 0 {
+		return nil, false
+	}
+
+	if clientCountry != "" {
+		if candidates := filterByField(active, func(n *Node) bool { return n.Country == clientCountry }); len(candidates) > 0 {
+			return weightedRendezvous(candidates, sessionID), true
+		}
+	}
+	if clientContinent != "" {
+		if candidates := filterByField(active, func(n *Node) bool { return n.Continent == clientContinent }); len(candidates) > 0 {
+			return weightedRendezvous(candidates, sessionID), true
+		}
+	}
+	return weightedRendezvous(active, sessionID), true
+}
+
+func filterByField(nodes []*Node, match func(*Node) bool) []*Node {
+	matched := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		if match(n) {
+			matched = append(matched, n)
+		}
+	}
+	return matched
+}
+
+func bestByRatio(nodes []*Node) *Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+	best := nodes[0]
+	bestRatio := ratio(best)
+	for _, n := range nodes[1:] {
+		if r := ratio(n); r < bestRatio {
+			best = n
+			bestRatio = r
+		}
+	}
+	return best
+}
+
+func ratio(n *Node) float64 {
+	if n.Capacity == 0 {
+		return 1
+	}
+	return float64(n.CurrentLoad) / float64(n.Capacity)
+}
+
+// StartEvictionLoop removes nodes whose last heartbeat is older than the
+// pool's ttl, checking once per interval. It never returns.
+func (p *Pool) StartEvictionLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		p.evictStale()
+	}
+}
+
+func (p *Pool) evictStale() {
+	cutoff := time.Now().Unix() - int64(p.ttl.Seconds())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, n := range p.nodes {
+		if n.LastPing < cutoff {
+			delete(p.nodes, id)
+		}
+	}
+}
+
+func rendezvousScore(sessionID, nodeID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(sessionID))
+	h.Write([]byte{'|'})
+	h.Write([]byte(nodeID))
+	return h.Sum64()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sseKeepaliveInterval is how often handleSessionSubscribe sends a comment
+// frame to keep idle connections (and the proxies in front of them) open.
+const sseKeepaliveInterval = 15 * time.Second
+
+// handleSessionSubscribe streams session state updates as Server-Sent
+// Events, for viewers behind proxies that block WebSockets or that only
+// need read-only playback state. It subscribes to the same Redis channel
+// broadcastState publishes to, so it sees updates regardless of which
+// coordinator instance the host's WebSocket landed on.
+func handleSessionSubscribe(w http.ResponseWriter, r *http.Request) {
+	sessionKey := mux.Vars(r)["key"]
+
+	exists, err := rdb.Exists(ctx, "session:"+sessionKey).Result()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "internal_server_error")
+		return
+	}
+	if exists == 0 {
+		respondError(w, http.StatusNotFound, "session_not_found")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	sub := rdb.Subscribe(ctx, sessionEventsChannel(sessionKey))
+	defer sub.Close()
+	events := sub.Channel()
+
+	var seq int64
+	if state, err := rdb.Get(ctx, "session:"+sessionKey+":state").Bytes(); err == nil {
+		initial, marshalErr := json.Marshal(map[string]interface{}{
+			"type":      "stateUpdate",
+			"state":     json.RawMessage(state),
+			"timestamp": time.Now().UnixMilli(),
+		})
+		if marshalErr == nil {
+			seq++
+			writeSSEEvent(w, flusher, seq, initial)
+		}
+	}
+
+	keepalive := time.NewTicker(sseKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case msg, ok := <-events:
+			if !ok {
+				return
+			}
+			seq++
+			writeSSEEvent(w, flusher, seq, []byte(msg.Payload))
+
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSSEEvent(w http.ResponseWriter, flusher http.Flusher, id int64, data []byte) {
+	if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", id, data); err != nil {
+		log.Printf("Error writing SSE event: %v", err)
+		return
+	}
+	flusher.Flush()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// drainTimeout is how long handleDrain waits for numClients to reach zero on
+// its own before forcing clients off with a serverMigrate notice.
+const drainTimeout = 30 * time.Second
+
+// draining is set once this server has been told to drain, so heartbeats
+// and /status stop advertising it as eligible for new session assignment.
+var draining int32
+
+func currentStatus() string {
+	if atomic.LoadInt32(&draining) == 1 {
+		return "draining"
+	}
+	return "active"
+}
+
+// drainRequest optionally carries the URL clients should reconnect to if
+// this server has to force them off before they've drained naturally.
+type drainRequest struct {
+	MigrateURL string `json:"migrateUrl"`
+}
+
+// handleDrain marks this server as draining (reported on the next heartbeat,
+// so the main server stops assigning it new sessions) and waits in the
+// background for its existing clients to leave on their own. If they haven't
+// by drainTimeout, it force-migrates them and exits.
+func handleDrain(w http.ResponseWriter, r *http.Request) {
+	var req drainRequest
+	json.NewDecoder(r.Body).Decode(&req) // best-effort; missing body is fine
+
+	atomic.StoreInt32(&draining, 1)
+	log.Printf("Draining server %s", serverID)
+
+	go waitForDrain(req.MigrateURL)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func waitForDrain(migrateURL string) {
+	deadline := time.Now().Add(drainTimeout)
+	for time.Now().Before(deadline) {
+		numClients_lock.Lock()
+		remaining := numClients
+		numClients_lock.Unlock()
+
+		if remaining == 0 {
+			log.Printf("Server %s drained cleanly, exiting", serverID)
+			os.Exit(0)
+		}
+		time.Sleep(time.Second)
+	}
+
+	log.Printf("Server %s drain timed out, forcing remaining clients to migrate", serverID)
+	forceMigrate(migrateURL)
+	time.Sleep(writeWait) // give writePump a moment to flush the notice
+	os.Exit(0)
+}
+
+func forceMigrate(migrateURL string) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"type": "serverMigrate",
+		"url":  migrateURL,
+	})
+	if err != nil {
+		log.Println("Error marshaling serverMigrate:", err)
+		return
+	}
+	broadcastToAllSessions(payload)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// handlePing answers a client's {type:"ping", t0} with the server receive
+// and send times, so the client can compute its RTT and clock offset
+// relative to the server (t3, the client's own receive time, never leaves
+// the client). The client is expected to report the result back via a
+// "clockReport" message so it shows up in /status.
+func handlePing(client *ClientConnection, clientSendMs int64) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":         "pong",
+		"t0":           clientSendMs,
+		"serverRecvMs": time.Now().UnixMilli(),
+		"serverSendMs": time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Println("Error marshaling pong:", err)
+		return
+	}
+
+	select {
+	case client.send <- payload:
+	default:
+		log.Printf("Dropping pong to client in session %s (send buffer full)", client.sessionID)
+	}
+}
+
+// ClientDriftInfo is one client's self-reported clock-sync measurements, as
+// surfaced by GET /status?sessionID=....
+type ClientDriftInfo struct {
+	IsHost   bool  `json:"isHost"`
+	RTTMs    int64 `json:"rttMs"`
+	OffsetMs int64 `json:"offsetMs"`
+}
+
+func (c *ClientConnection) driftInfo() ClientDriftInfo {
+	return ClientDriftInfo{
+		IsHost:   c.isHost,
+		RTTMs:    atomic.LoadInt64(&c.lastRTTMs),
+		OffsetMs: atomic.LoadInt64(&c.lastOffsetMs),
+	}
+}
+
+// handleStatus reports server load, and, when a sessionID is given, the
+// drift measurements of that session's connected clients for debugging.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := map[string]interface{}{
+		"id":          serverID,
+		"url":         serverURL,
+		"capacity":    capacity,
+		"currentLoad": numClients,
+		"status":      currentStatus(),
+		"lastPing":    time.Now().Unix(),
+	}
+
+	if sessionID := r.URL.Query().Get("sessionID"); sessionID != "" {
+		status["clients"] = sessionDrift(sessionID)
+	}
+
+	respondJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LL-HLS blocking playlist reload (https://www.rfc-editor.org/rfc/rfc8216#section-6.2.6
+// as extended by the LL-HLS draft): a client appends _HLS_msn (and
+// optionally _HLS_part) to ask the server to hold the response until that
+// media sequence number (and part) is available, instead of polling on a
+// fixed interval. Our playlists live on disk and are written by the
+// transcoder in a separate process, so "waiting for it to become available"
+// is a short poll loop rather than a single in-process wakeup.
+//
+// In the current pipeline this has nothing to usefully wait on: a session's
+// renditions are transcoded whole (ffmpeg -hls_playlist_type vod) and only
+// exposed once the job reaches "ready", so by the time a playlist is ever
+// served it's already complete with #EXT-X-ENDLIST - every request just
+// polls until blockingReloadTimeout and returns what's already there. It's
+// scaffolding for a future incremental/live transcode path, not the
+// sub-2s glass-to-glass latency LL-HLS is normally used for.
+const (
+	blockingReloadTimeout = 4 * time.Second
+	blockingReloadPoll    = 100 * time.Millisecond
+)
+
+// awaitMediaSequence blocks until the last segment listed in playlistPath
+// has a media sequence number of at least wantMSN, or blockingReloadTimeout
+// elapses.
+func awaitMediaSequence(playlistPath string, wantMSN int) {
+	deadline := time.Now().Add(blockingReloadTimeout)
+	for {
+		if last, err := lastAvailableSequence(playlistPath); err == nil && last >= wantMSN {
+			return
+		}
+		if time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(blockingReloadPoll)
+	}
+}
+
+// lastAvailableSequence returns the media sequence number of the last
+// segment currently listed in playlistPath.
+func lastAvailableSequence(playlistPath string) (int, error) {
+	f, err := os.Open(playlistPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	mediaSequence := 0
+	segmentCount := 0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			if n, err := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:")); err == nil {
+				mediaSequence = n
+			}
+		case strings.HasPrefix(line, "#EXTINF"):
+			segmentCount++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if segmentCount == 0 {
+		return 0, os.ErrNotExist
+	}
+	return mediaSequence + segmentCount - 1, nil
+}
+
+// handleBlockingReload parses the LL-HLS _HLS_msn/_HLS_part query params off
+// r and, if present, waits for that media sequence number to show up in
+// playlistPath before the caller serves it. _HLS_part is accepted (and
+// validated) for spec compliance, but doesn't change what we wait for: our
+// parts are all cut from an already-complete segment (see transcoder/llhls.go),
+// so no part is ever available before its parent segment is, and waiting on
+// the segment alone already covers it.
+func handleBlockingReload(r *http.Request, playlistPath string) {
+	msnParam := r.URL.Query().Get("_HLS_msn")
+	if msnParam == "" {
+		return
+	}
+	msn, err := strconv.Atoi(msnParam)
+	if err != nil {
+		return
+	}
+
+	if partParam := r.URL.Query().Get("_HLS_part"); partParam != "" {
+		if _, err := strconv.Atoi(partParam); err != nil {
+			return
+		}
+	}
+
+	awaitMediaSequence(playlistPath, msn)
+}
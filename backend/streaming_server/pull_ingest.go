@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"videosync/backend/hlsclient"
+)
+
+// pullClients tracks the active hlsclient.Client per session so it can be
+// stopped when the session's last local WebSocket client disconnects (see
+// SessionHub.run's teardown).
+var (
+	pullClients   = make(map[string]*hlsclient.Client)
+	pullClientsMu sync.Mutex
+)
+
+type pullIngestRequest struct {
+	URL string `json:"url"`
+}
+
+// handlePullIngest seeds a session by mirroring an external HLS source,
+// rather than an uploaded file: POST {url: "https://.../master.m3u8"}.
+func handlePullIngest(w http.ResponseWriter, r *http.Request) {
+	handleCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	sessionID := mux.Vars(r)["sessionID"]
+	if sessionID == "" {
+		http.Error(w, "Missing sessionID", http.StatusBadRequest)
+		return
+	}
+
+	var req pullIngestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		http.Error(w, "Missing or invalid 'url'", http.StatusBadRequest)
+		return
+	}
+
+	if err := hlsclient.ValidateURL(req.URL); err != nil {
+		log.Printf("Rejected pull ingest URL for session %s: %v", sessionID, err)
+		http.Error(w, "Invalid or disallowed 'url'", http.StatusBadRequest)
+		return
+	}
+
+	client := hlsclient.New(sessionID, req.URL, HLS_BASE_DIR)
+	if err := client.Start(context.Background()); err != nil {
+		log.Printf("Error starting pull ingest for session %s: %v", sessionID, err)
+		http.Error(w, "Could not start pull ingest", http.StatusInternalServerError)
+		return
+	}
+
+	pullClientsMu.Lock()
+	if existing, ok := pullClients[sessionID]; ok {
+		existing.Stop()
+	}
+	pullClients[sessionID] = client
+	pullClientsMu.Unlock()
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"sessionID": sessionID, "status": "pulling"})
+}
+
+// stopPullIngest cancels and forgets sessionID's puller, if any.
+func stopPullIngest(sessionID string) {
+	pullClientsMu.Lock()
+	defer pullClientsMu.Unlock()
+
+	if client, ok := pullClients[sessionID]; ok {
+		client.Stop()
+		delete(pullClients, sessionID)
+	}
+}
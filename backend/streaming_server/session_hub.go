@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// SessionHub owns every ClientConnection for one sessionID, plus that
+// session's single Redis subscription. It is the only goroutine that
+// delivers broadcasts (Redis state updates and broadcastToAllSessions
+// payloads) to each client's send channel, once per client per payload.
+// It is not the only writer to send: each connection's own read loop also
+// queues its direct replies there (initial state, pong, heartbeat ack,
+// video metadata). Clients join/leave through the
+// register/unregister channels rather than touching a shared map directly,
+// so there's nothing left to guard with client_lock.
+type SessionHub struct {
+	sessionID  string
+	clients    map[*ClientConnection]bool
+	register   chan *ClientConnection
+	unregister chan *ClientConnection
+	broadcast  chan []byte
+	pubsub     *redis.PubSub
+	statsReq   chan chan []ClientDriftInfo
+}
+
+var (
+	hubs   = make(map[string]*SessionHub)
+	hubsMu sync.Mutex
+)
+
+func sessionUpdatesChannel(sessionID string) string {
+	return "session-updates:" + sessionID
+}
+
+// getOrCreateHub returns the running hub for sessionID, starting one (and
+// its Redis subscription) if this is the first client for that session.
+func getOrCreateHub(sessionID string) *SessionHub {
+	hubsMu.Lock()
+	defer hubsMu.Unlock()
+
+	if hub, exists := hubs[sessionID]; exists {
+		return hub
+	}
+
+	hub := &SessionHub{
+		sessionID:  sessionID,
+		clients:    make(map[*ClientConnection]bool),
+		register:   make(chan *ClientConnection),
+		unregister: make(chan *ClientConnection),
+		broadcast:  make(chan []byte, 16),
+		pubsub:     rdb.Subscribe(ctx, sessionUpdatesChannel(sessionID)),
+		statsReq:   make(chan chan []ClientDriftInfo),
+	}
+	hubs[sessionID] = hub
+	go hub.run()
+	return hub
+}
+
+func (h *SessionHub) run() {
+	redisCh := h.pubsub.Channel()
+	for {
+		select {
+		case client := <-h.register:
+			h.clients[client] = true
+
+		case client := <-h.unregister:
+			if _, ok := h.clients[client]; ok {
+				delete(h.clients, client)
+				close(client.send)
+			}
+			if len(h.clients) == 0 {
+				h.pubsub.Close()
+				stopPullIngest(h.sessionID)
+				hubsMu.Lock()
+				delete(hubs, h.sessionID)
+				hubsMu.Unlock()
+				return
+			}
+
+		case payload := <-h.broadcast:
+			h.deliver(payload)
+
+		case respCh := <-h.statsReq:
+			snapshot := make([]ClientDriftInfo, 0, len(h.clients))
+			for client := range h.clients {
+				snapshot = append(snapshot, client.driftInfo())
+			}
+			respCh <- snapshot
+
+		case msg, ok := <-redisCh:
+			if !ok {
+				return
+			}
+			if payload := wrapStateUpdate(json.RawMessage(msg.Payload)); payload != nil {
+				h.deliver(payload)
+			}
+		}
+	}
+}
+
+func (h *SessionHub) deliver(payload []byte) {
+	for client := range h.clients {
+		select {
+		case client.send <- payload:
+		default:
+			log.Printf("Dropping message to client in session %s (send buffer full)", h.sessionID)
+		}
+	}
+}
+
+// sessionDrift returns the connected clients' drift measurements for
+// sessionID, or nil if that session has no active hub.
+func sessionDrift(sessionID string) []ClientDriftInfo {
+	hubsMu.Lock()
+	hub, exists := hubs[sessionID]
+	hubsMu.Unlock()
+	if !exists {
+		return nil
+	}
+
+	respCh := make(chan []ClientDriftInfo)
+	hub.statsReq <- respCh
+	return <-respCh
+}
+
+// broadcastToAllSessions delivers payload to every client on this server,
+// across all sessions - used by the drain handler's serverMigrate notice.
+func broadcastToAllSessions(payload []byte) {
+	hubsMu.Lock()
+	targets := make([]*SessionHub, 0, len(hubs))
+	for _, hub := range hubs {
+		targets = append(targets, hub)
+	}
+	hubsMu.Unlock()
+
+	for _, hub := range targets {
+		hub.broadcast <- payload
+	}
+}
+
+func wrapStateUpdate(state json.RawMessage) []byte {
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":       "stateUpdate",
+		"state":      state,
+		"servertime": time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Println("Error marshaling state update:", err)
+		return nil
+	}
+	return payload
+}
@@ -8,10 +8,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -27,6 +29,8 @@ type StreamingServer struct {
 	CurrentLoad int
 	Status      string
 	LastPing    int64
+	Country     string
+	Continent   string
 }
 
 type ClientConnection struct {
@@ -34,6 +38,12 @@ type ClientConnection struct {
 	sessionID string
 	isHost    bool
 	send      chan []byte
+
+	// lastRTTMs/lastOffsetMs are this client's most recently self-reported
+	// clock-sync measurements (see drift.go), read from handleStatus and
+	// written from handleClientMessage, possibly on different goroutines.
+	lastRTTMs    int64
+	lastOffsetMs int64
 }
 
 type RedisState struct {
@@ -41,6 +51,12 @@ type RedisState struct {
 	CurrentTime  float64 `json:"currentTime"`
 	PlaybackRate float64 `json:"playbackRate"`
 	Timestamp    int64   `json:"timestamp"`
+
+	// AnchorServerTime is the server time (UnixMilli) that CurrentTime
+	// corresponds to, set by the host's one-way network delay estimate so
+	// viewers can extrapolate drift instead of trusting a raw timestamp
+	// compare. See drift.go.
+	AnchorServerTime int64 `json:"anchorServerTime"`
 }
 
 type VideoManifest struct {
@@ -52,14 +68,14 @@ type VideoManifest struct {
 
 var (
 	// [TODO] Get the below 4 param through command line
-	mainServerURL = "http://localhost:8080"
-	serverID      = os.Getenv("SERVER_ID")
-	serverURL     = os.Getenv("SERVER_URL")
-	serverPort    = os.Getenv("SERVER_PORT")
-	capacity      = 100 // Default capacity
-
-	clients         = make(map[string][]*ClientConnection)
-	client_lock     = make(map[string]*sync.Mutex)
+	mainServerURL   = "http://localhost:8080"
+	serverID        = os.Getenv("SERVER_ID")
+	serverURL       = os.Getenv("SERVER_URL")
+	serverPort      = os.Getenv("SERVER_PORT")
+	serverCountry   = os.Getenv("SERVER_COUNTRY")
+	serverContinent = os.Getenv("SERVER_CONTINENT")
+	capacity        = 100 // Default capacity
+
 	numClients      = 0
 	numClients_lock = &sync.Mutex{}
 
@@ -71,8 +87,7 @@ var (
 		},
 	}
 
-	rdb    *redis.Client
-	pubsub *redis.PubSub
+	rdb *redis.Client
 )
 
 // HLS directory structure
@@ -86,6 +101,16 @@ const (
 	CHUNK_DURATION     = 5
 )
 
+// WebSocket connection tuning, following the standard gorilla read/write
+// deadline + ping/pong pattern so a dead or slow client gets dropped
+// deterministically instead of silently losing messages.
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 1 << 20 // 1 MB, generous for JSON state messages
+)
+
 var ctx = context.Background()
 
 func main() {
@@ -130,13 +155,21 @@ func main() {
 	r := mux.NewRouter()
 	r.HandleFunc("/ws", handleWebSocket)
 	r.HandleFunc("/status", handleStatus)
+	r.HandleFunc("/api/session/{sessionID}/pull", handlePullIngest).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/drain", handleDrain).Methods("POST")
 
-	// HLS routes
+	// master.m3u8 is fetched unauthenticated by the coordinator itself (see
+	// handleSessionManifest), which then rewrites every variant URI with a
+	// signed viewer token - so only the variant playlists and segments
+	// actually carrying viewer content need to be gated behind one.
 	r.HandleFunc("/hls/{sessionID}/master.m3u8", serveHLSMasterPlaylist).Methods("GET", "OPTIONS")
-	r.HandleFunc("/hls/{sessionID}/playlist.m3u8", serveHLSMediaPlaylist).Methods("GET", "OPTIONS")
-	r.HandleFunc("/hls/{sessionID}/{quality}/playlist.m3u8", serveHLSQualityPlaylist).Methods("GET", "OPTIONS")
-	r.HandleFunc("/hls/{sessionID}/{segmentName}", serveHLSSegment).Methods("GET", "OPTIONS")
-	r.HandleFunc("/hls/{sessionID}/{quality}/{segmentName}", serveHLSQualitySegment).Methods("GET", "OPTIONS")
+
+	hlsRouter := r.PathPrefix("/hls").Subrouter()
+	hlsRouter.Use(requireViewerToken)
+	hlsRouter.HandleFunc("/{sessionID}/playlist.m3u8", serveHLSMediaPlaylist).Methods("GET", "OPTIONS")
+	hlsRouter.HandleFunc("/{sessionID}/{quality}/playlist.m3u8", serveHLSQualityPlaylist).Methods("GET", "OPTIONS")
+	hlsRouter.HandleFunc("/{sessionID}/{segmentName}", serveHLSSegment).Methods("GET", "OPTIONS")
+	hlsRouter.HandleFunc("/{sessionID}/{quality}/{segmentName}", serveHLSQualitySegment).Methods("GET", "OPTIONS")
 
 	// Wrap the router with Gorilla's CORS handler:
 	corsHandler := handlers.CORS(
@@ -158,6 +191,8 @@ func registerWithMainServer() {
 		CurrentLoad: 0,
 		Status:      "active",
 		LastPing:    time.Now().Unix(),
+		Country:     serverCountry,
+		Continent:   serverContinent,
 	}
 
 	jsonData, err := json.Marshal(server)
@@ -187,7 +222,7 @@ func sendHeartbeats() {
 			URL:         serverURL,
 			Capacity:    capacity,
 			CurrentLoad: numClients,
-			Status:      "active",
+			Status:      currentStatus(),
 			LastPing:    time.Now().Unix(),
 		}
 		numClients_lock.Unlock()
@@ -228,23 +263,23 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client.send = make(chan []byte, 256)
-	go client.writePump()
 
-	// Initialize mutex for this session if it doesn't exist
-	if _, exists := client_lock[sessionID]; !exists {
-		client_lock[sessionID] = &sync.Mutex{}
-	}
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
 
-	client_lock[sessionID].Lock()
-	clients[sessionID] = append(clients[sessionID], client)
-	client_lock[sessionID].Unlock()
+	go client.writePump()
+
+	hub := getOrCreateHub(sessionID)
+	hub.register <- client
 
 	numClients_lock.Lock()
 	numClients += 1
 	numClients_lock.Unlock()
-	defer cleanupClient(client)
-
-	subscribeToSessionUpdates(sessionID)
+	defer cleanupClient(hub, client)
 
 	// Send the initial state to the client
 	if !client.isHost {
@@ -295,26 +330,55 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *ClientConnection) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
 	for {
-		msg, ok := <-c.send
-		if !ok {
-			// Channel closed, close the WebSocket
-			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-			return
-		}
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// Channel closed, close the WebSocket
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Println("writePump error:", err)
+				return
+			}
 
-		err := c.conn.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
-			log.Println("writePump error:", err)
-			return
+			// Drain anything else already queued before going back to
+			// waiting, so a burst of state updates doesn't pile up behind
+			// the next ping tick.
+			for n := len(c.send); n > 0; n-- {
+				queued := <-c.send
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.conn.WriteMessage(websocket.TextMessage, queued); err != nil {
+					log.Println("writePump error:", err)
+					return
+				}
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
 
 func handleClientMessage(client *ClientConnection, message []byte) {
 	var msg struct {
-		Type  string          `json:"type"`
-		State json.RawMessage `json:"state"`
+		Type          string          `json:"type"`
+		State         json.RawMessage `json:"state"`
+		OneWayDelayMs int64           `json:"oneWayDelayMs"` // stateUpdate: host's estimated one-way network delay
+		T0            int64           `json:"t0"`            // ping: client send time (client clock, ms)
+		RTTMs         int64           `json:"rttMs"`         // clockReport
+		OffsetMs      int64           `json:"offsetMs"`      // clockReport
 	}
 
 	if err := json.Unmarshal(message, &msg); err != nil {
@@ -353,17 +417,33 @@ func handleClientMessage(client *ClientConnection, message []byte) {
 
 			// Compare the timestamps
 			if stateFromMsg.Timestamp > stateFromRedis.Timestamp {
-				stateJson, _ := json.Marshal(msg.State)
-				err := rdb.SetEX(ctx, "session:"+client.sessionID+":state", stateJson, REDIS_MSG_EXPIRY).Err()
+				// Anchor this state to the server time it actually took
+				// effect at, backdated by the host's reported one-way
+				// delay, so viewers can extrapolate drift instead of
+				// trusting the raw timestamp.
+				stateFromMsg.AnchorServerTime = time.Now().UnixMilli() - msg.OneWayDelayMs
+				stateJson, err := json.Marshal(stateFromMsg)
 				if err != nil {
+					log.Println("Error marshaling anchored state:", err)
+					return
+				}
+
+				if err := rdb.SetEX(ctx, "session:"+client.sessionID+":state", stateJson, REDIS_MSG_EXPIRY).Err(); err != nil {
 					log.Println("Error updating state in Redis:", err)
 				}
 
 				// Publish the state update to all clients in this session
-				publishStateUpdate(client.sessionID, msg.State)
+				publishStateUpdate(client.sessionID, stateJson)
 			}
 		}
 
+	case "ping":
+		handlePing(client, msg.T0)
+
+	case "clockReport":
+		atomic.StoreInt64(&client.lastRTTMs, msg.RTTMs)
+		atomic.StoreInt64(&client.lastOffsetMs, msg.OffsetMs)
+
 	case "videoMetadata":
 		videoMetadata := VideoManifest{
 			ChunkDuration: 5,
@@ -384,63 +464,44 @@ func handleClientMessage(client *ClientConnection, message []byte) {
 		client.send <- payload
 
 	case "heartbeat":
-		// Send heartbeat acknowledgment
-		client.conn.WriteJSON(map[string]string{"type": "heartbeatAck"})
+		// Send heartbeat acknowledgment through client.send, same as every
+		// other reply, so writePump stays the only goroutine that ever
+		// writes to client.conn.
+		ackPayload, err := json.Marshal(map[string]string{"type": "heartbeatAck"})
+		if err != nil {
+			log.Println("Error marshaling heartbeat ack:", err)
+			return
+		}
+		select {
+		case client.send <- ackPayload:
+		default:
+			log.Printf("Dropping heartbeat ack to client in session %s (send buffer full)", client.sessionID)
+		}
 	}
 }
 
-func cleanupClient(client *ClientConnection) {
-	if client == nil || client.sessionID == "" {
-		return
-	}
-	if client.send != nil {
-		close(client.send)
-	}
-
-	if _, exists := client_lock[client.sessionID]; !exists {
-		client_lock[client.sessionID] = &sync.Mutex{}
-	}
-
-	client_lock[client.sessionID].Lock()
-	sessionClients, exists := clients[client.sessionID]
-	if !exists || len(sessionClients) == 0 {
-		client_lock[client.sessionID].Unlock()
+// cleanupClient unregisters client from its hub (which closes client.send
+// once removed) and closes the underlying connection. The hub itself tears
+// down and unsubscribes from Redis once its last client leaves.
+func cleanupClient(hub *SessionHub, client *ClientConnection) {
+	if client == nil {
 		return
 	}
 
-	for i, c := range sessionClients {
-		if c == client {
-			// Safely close the connection
-			if client.conn != nil {
-				client.conn.Close()
-			}
-
-			// Remove the client from the slice
-			clients[client.sessionID] = append(sessionClients[:i], sessionClients[i+1:]...)
-			break
-		}
+	hub.unregister <- client
+	if client.conn != nil {
+		client.conn.Close()
 	}
-	client_lock[client.sessionID].Unlock()
 
 	numClients_lock.Lock()
 	numClients -= 1
 	numClients_lock.Unlock()
 }
 
-func handleStatus(w http.ResponseWriter, r *http.Request) {
-	status := map[string]interface{}{
-		"id":          serverID,
-		"url":         serverURL,
-		"capacity":    capacity,
-		"currentLoad": numClients,
-		"status":      "active",
-		"lastPing":    time.Now().Unix(),
-	}
-
-	respondJSON(w, http.StatusOK, status)
-}
-
 // ////////////////////////////////////// PUBSUB FUNCTIONS //////////////////////////////////////////////////////////////
+// publishStateUpdate publishes a host's state update to the session's Redis
+// channel; each process's SessionHub picks it up through its own
+// subscription and fans it out to its local clients.
 func publishStateUpdate(sessionID string, state json.RawMessage) {
 	ctx := context.Background()
 	payload, err := json.Marshal(state)
@@ -449,91 +510,12 @@ func publishStateUpdate(sessionID string, state json.RawMessage) {
 		return
 	}
 
-	err = rdb.Publish(ctx, "session-updates:"+sessionID, string(payload)).Err()
+	err = rdb.Publish(ctx, sessionUpdatesChannel(sessionID), string(payload)).Err()
 	if err != nil {
 		log.Println("Error publishing state update:", err)
 	}
 }
 
-func subscribeToSessionUpdates(sessionID string) {
-	ctx := context.Background()
-	pubsub = rdb.Subscribe(ctx, "session-updates:"+sessionID)
-
-	go func() {
-		for {
-			msg, err := pubsub.ReceiveMessage(ctx)
-			if err != nil {
-				log.Println("Error receiving message:", err)
-				continue
-			}
-
-			// Process received message
-			handleSessionUpdate(msg.Channel, msg.Payload)
-		}
-	}()
-}
-
-// Handle session updates received from Redis pub/sub
-func handleSessionUpdate(channel, payload string) {
-	// Extract sessionID from channel
-	sessionID := channel[len("session-updates:"):]
-	log.Printf("Received update for session %s: %s", sessionID, payload)
-
-	var state json.RawMessage
-	err := json.Unmarshal([]byte(payload), &state)
-	if err != nil {
-		log.Println("Error unmarshaling state:", err)
-		return
-	}
-	broadcastState(sessionID, state)
-}
-
-func broadcastState(sessionID string, state json.RawMessage) {
-	if _, exists := client_lock[sessionID]; !exists {
-		client_lock[sessionID] = &sync.Mutex{}
-	}
-
-	client_lock[sessionID].Lock()
-	// Check if the session exists in the clients map
-	sessionClients, exists := clients[sessionID]
-	if !exists || len(sessionClients) == 0 {
-		client_lock[sessionID].Unlock()
-		return
-	}
-
-	clientsToSend := make([]*ClientConnection, len(sessionClients))
-	copy(clientsToSend, sessionClients)
-	client_lock[sessionID].Unlock()
-
-	for _, client := range clientsToSend {
-		if client == nil || client.conn == nil {
-			continue
-		}
-
-		payload, err := json.Marshal(map[string]interface{}{
-			"type":       "stateUpdate",
-			"state":      state,
-			"servertime": time.Now().UnixMilli(),
-		})
-		if err != nil {
-			log.Printf("Error marshaling broadcast state: %v", err)
-			return
-		}
-
-		for _, client := range clientsToSend {
-			if client == nil || client.conn == nil {
-				continue
-			}
-			select {
-			case client.send <- payload:
-			default:
-				log.Printf("Dropping message to client in session %s (send buffer full)", sessionID)
-			}
-		}
-
-	}
-}
-
 /////////////////////////////////////// HELPER FUNCTIONS //////////////////////////////////////////////////////////////
 
 func respondJSON(w http.ResponseWriter, statusCode int, data interface{}) {
@@ -578,7 +560,7 @@ func serveHLSMasterPlaylist(w http.ResponseWriter, r *http.Request) {
 	sessionID := vars["sessionID"]
 
 	// Check if the master playlist file exists
-	masterPath := filepath.Join(HLS_BASE_DIR, HLS_MASTER_NAME)
+	masterPath := filepath.Join(HLS_BASE_DIR, sessionID, HLS_MASTER_NAME)
 	if _, err := os.Stat(masterPath); err == nil {
 		// Master playlist exists, serve it
 		http.ServeFile(w, r, masterPath)
@@ -600,13 +582,15 @@ func serveHLSMediaPlaylist(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	sessionID := vars["sessionID"]
 
-	playlistPath := filepath.Join(HLS_BASE_DIR, HLS_PLAYLIST_NAME)
+	playlistPath := filepath.Join(HLS_BASE_DIR, sessionID, HLS_PLAYLIST_NAME)
 	// Check if playlist exists
 	if _, err := os.Stat(playlistPath); os.IsNotExist(err) {
 		http.Error(w, "Playlist not found", http.StatusNotFound)
 		return
 	}
 
+	handleBlockingReload(r, playlistPath)
+
 	// Read the playlist content
 	playlistContent, err := os.ReadFile(playlistPath)
 	if err != nil {
@@ -633,7 +617,7 @@ func serveHLSQualityPlaylist(w http.ResponseWriter, r *http.Request) {
 	sessionID := vars["sessionID"]
 	quality := vars["quality"]
 
-	playlistPath := filepath.Join(HLS_BASE_DIR, quality, HLS_PLAYLIST_NAME)
+	playlistPath := filepath.Join(HLS_BASE_DIR, sessionID, quality, HLS_PLAYLIST_NAME)
 
 	// Check if playlist exists
 	if _, err := os.Stat(playlistPath); os.IsNotExist(err) {
@@ -641,8 +625,23 @@ func serveHLSQualityPlaylist(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Read the playlist content
-	http.ServeFile(w, r, playlistPath)
+	handleBlockingReload(r, playlistPath)
+
+	playlistContent, err := os.ReadFile(playlistPath)
+	if err != nil {
+		http.Error(w, "Error reading playlist", http.StatusInternalServerError)
+		log.Printf("Error reading %s playlist for session %s: %v", quality, sessionID, err)
+		return
+	}
+
+	tokenQuery := url.Values{}
+	tokenQuery.Set("viewerID", r.URL.Query().Get("viewerID"))
+	tokenQuery.Set("expiry", r.URL.Query().Get("expiry"))
+	tokenQuery.Set("token", r.URL.Query().Get("token"))
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(signPlaylistSegments(playlistContent, tokenQuery))
 	log.Printf("Served %s playlist for session %s", quality, sessionID)
 }
 
@@ -664,7 +663,7 @@ func serveHLSSegment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	segmentPath := filepath.Join(HLS_BASE_DIR, HLS_SEGMENT_DIR, segmentName)
+	segmentPath := filepath.Join(HLS_BASE_DIR, sessionID, HLS_SEGMENT_DIR, segmentName)
 	log.Printf("Serving segment %s for session %s", segmentPath, sessionID)
 	// Check if segment exists
 	if _, err := os.Stat(segmentPath); os.IsNotExist(err) {
@@ -696,7 +695,7 @@ func serveHLSQualitySegment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	segmentPath := filepath.Join(HLS_BASE_DIR, quality, segmentName)
+	segmentPath := filepath.Join(HLS_BASE_DIR, sessionID, quality, segmentName)
 	log.Printf("Serving segment %s for session %s", segmentPath, sessionID)
 	// Check if segment exists
 	if _, err := os.Stat(segmentPath); os.IsNotExist(err) {
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"videosync/backend/viewertoken"
+)
+
+// requireViewerToken gates the /hls routes behind the signed viewerID/
+// expiry/token query params the coordinator embeds in manifests it
+// rewrites (see handleSessionManifest), so segments can't be pulled by
+// anyone who hasn't gone through session validation first.
+func requireViewerToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sessionID := mux.Vars(r)["sessionID"]
+		viewerID := r.URL.Query().Get("viewerID")
+		token := r.URL.Query().Get("token")
+
+		expiry, err := strconv.ParseInt(r.URL.Query().Get("expiry"), 10, 64)
+		if err != nil || !viewertoken.Valid(sessionID, viewerID, expiry, token) {
+			http.Error(w, "Invalid or expired viewer token", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// signPlaylistSegments rewrites every segment and LL-HLS part URI in a
+// variant playlist to carry tokenQuery (the same viewerID/expiry/token this
+// request was itself authorized with), so resolving those relative URIs
+// against the playlist's URL - which drops the playlist URL's own query
+// string per normal URL-reference resolution - still lands on an
+// authorized request instead of 403ing against requireViewerToken.
+func signPlaylistSegments(content []byte, tokenQuery url.Values) []byte {
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-PART:"):
+			lines[i] = signPartURI(line, tokenQuery)
+		case line != "" && !strings.HasPrefix(line, "#"):
+			lines[i] = appendTokenQuery(line, tokenQuery)
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// signPartURI rewrites the URI="..." attribute of a single EXT-X-PART line.
+func signPartURI(line string, tokenQuery url.Values) string {
+	const marker = `URI="`
+	start := strings.Index(line, marker)
+	if start == -1 {
+		return line
+	}
+	start += len(marker)
+	end := strings.Index(line[start:], `"`)
+	if end == -1 {
+		return line
+	}
+	end += start
+	return line[:start] + appendTokenQuery(line[start:end], tokenQuery) + line[end:]
+}
+
+func appendTokenQuery(uri string, tokenQuery url.Values) string {
+	if uri == "" {
+		return uri
+	}
+	sep := "?"
+	if strings.Contains(uri, "?") {
+		sep = "&"
+	}
+	return uri + sep + tokenQuery.Encode()
+}
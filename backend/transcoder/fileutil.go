@@ -0,0 +1,11 @@
+package transcoder
+
+import "os"
+
+func ensureDir(path string) error {
+	return os.MkdirAll(path, os.ModePerm)
+}
+
+func writeFile(path, contents string) error {
+	return os.WriteFile(path, []byte(contents), 0o644)
+}
@@ -0,0 +1,101 @@
+package transcoder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LL-HLS tuning. Our source is always a fully-uploaded file rather than a
+// live feed, so there's no benefit to shipping genuinely independent partial
+// segments — instead each completed .ts segment is cut into sub-second
+// "parts" addressed by BYTERANGE, which is enough for hls.js to start
+// rendering a segment before the whole thing has been fetched.
+const (
+	partTargetSeconds   = 0.5
+	partHoldBackSeconds = partTargetSeconds * 3
+	partsPerSegment     = int(CHUNK_DURATION_SECONDS / partTargetSeconds)
+)
+
+// CHUNK_DURATION_SECONDS matches the -hls_time passed to ffmpeg.
+const CHUNK_DURATION_SECONDS = 5
+
+// injectLLHLSTags rewrites a freshly-produced media playlist to add the
+// PART-INF/SERVER-CONTROL header and, for every #EXTINF segment, a run of
+// EXT-X-PART entries carved out of that same segment file via BYTERANGE.
+func injectLLHLSTags(playlistPath string) error {
+	f, err := os.Open(playlistPath)
+	if err != nil {
+		return err
+	}
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	dir := strings.TrimSuffix(playlistPath, "/playlist.m3u8")
+
+	var out strings.Builder
+	headerWritten := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		out.WriteString(line)
+		out.WriteByte('\n')
+
+		if !headerWritten && strings.HasPrefix(line, "#EXT-X-TARGETDURATION") {
+			fmt.Fprintf(&out, "#EXT-X-PART-INF:PART-TARGET=%.1f\n", partTargetSeconds)
+			fmt.Fprintf(&out, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.1f\n", partHoldBackSeconds)
+			headerWritten = true
+			continue
+		}
+
+		if strings.HasPrefix(line, "#EXTINF") && i+1 < len(lines) {
+			segmentName := lines[i+1]
+			segmentPath := dir + "/" + segmentName
+			if parts, err := partsForSegment(segmentPath, segmentName); err == nil {
+				for _, p := range parts {
+					out.WriteString(p)
+					out.WriteByte('\n')
+				}
+			}
+		}
+	}
+
+	return writeFile(playlistPath, out.String())
+}
+
+// partsForSegment slices segmentPath into partsPerSegment roughly-equal
+// byte ranges and returns the corresponding EXT-X-PART lines.
+func partsForSegment(segmentPath, segmentName string) ([]string, error) {
+	info, err := os.Stat(segmentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	total := info.Size()
+	partSize := total / int64(partsPerSegment)
+	if partSize == 0 {
+		return nil, nil
+	}
+
+	parts := make([]string, 0, partsPerSegment)
+	var offset int64
+	for i := 0; i < partsPerSegment; i++ {
+		length := partSize
+		if i == partsPerSegment-1 {
+			length = total - offset // last part absorbs any remainder
+		}
+		parts = append(parts, fmt.Sprintf(
+			"#EXT-X-PART:DURATION=%.1f,URI=%q,BYTERANGE=%d@%d",
+			partTargetSeconds, segmentName, length, offset,
+		))
+		offset += length
+	}
+	return parts, nil
+}
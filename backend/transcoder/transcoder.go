@@ -0,0 +1,261 @@
+// Package transcoder turns an uploaded source video into an adaptive-bitrate
+// HLS ladder by shelling out to ffmpeg, and tracks job progress in Redis so
+// the upload server can answer status polls without holding any job state
+// itself.
+package transcoder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Job status values, also used verbatim as the "status" field returned by
+// the /api/video/{sessionID}/status endpoint.
+const (
+	StatusQueued      = "queued"
+	StatusTranscoding = "transcoding"
+	StatusReady       = "ready"
+	StatusFailed      = "failed"
+
+	// HLSBaseDir mirrors streaming_server's HLS_BASE_DIR: both processes
+	// run out of a sibling directory under backend/, so "../hls" resolves
+	// to the same place on disk.
+	HLSBaseDir = "../hls"
+
+	jobExpiry = 24 * time.Hour
+)
+
+// Rendition describes one variant in the ABR ladder.
+type Rendition struct {
+	Name       string // e.g. "240p", also the directory name under hls/<sessionID>/
+	Width      int
+	Height     int
+	BitrateKbs int
+	Codecs     string
+}
+
+var ladder = []Rendition{
+	{Name: "240p", Width: 426, Height: 240, BitrateKbs: 400, Codecs: "avc1.64000d,mp4a.40.2"},
+	{Name: "480p", Width: 854, Height: 480, BitrateKbs: 1000, Codecs: "avc1.64001e,mp4a.40.2"},
+	{Name: "720p", Width: 1280, Height: 720, BitrateKbs: 2500, Codecs: "avc1.64001f,mp4a.40.2"},
+	{Name: "1080p", Width: 1920, Height: 1080, BitrateKbs: 5000, Codecs: "avc1.640028,mp4a.40.2"},
+}
+
+// Job is the record persisted to Redis under job:<id>.
+type Job struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionID"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+func jobKey(jobID string) string {
+	return "job:" + jobID
+}
+
+func sessionJobKey(sessionID string) string {
+	return "session:" + sessionID + ":job"
+}
+
+func contentHashJobKey(contentHash string) string {
+	return "contenthash:" + contentHash + ":job"
+}
+
+// Enqueue records a queued job for sessionID and starts transcoding
+// inputPath in the background. It returns the job ID immediately; callers
+// should poll GetStatus (or the /status HTTP endpoint) for completion.
+func Enqueue(rdb *redis.Client, sessionID, inputPath string) (string, error) {
+	ctx := context.Background()
+	jobID := fmt.Sprintf("%s-%d", sessionID, time.Now().UnixNano())
+
+	job := Job{
+		ID:        jobID,
+		SessionID: sessionID,
+		Status:    StatusQueued,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := saveJob(ctx, rdb, job); err != nil {
+		return "", err
+	}
+	if err := rdb.Set(ctx, sessionJobKey(sessionID), jobID, jobExpiry).Err(); err != nil {
+		return "", err
+	}
+
+	go run(rdb, job, inputPath)
+
+	return jobID, nil
+}
+
+// EnqueueOrAlias is like Enqueue, but skips transcoding entirely when
+// contentHash already has a ready job from a different (earlier) session
+// uploading identical bytes: sessionID's HLS output directory is symlinked
+// to that job's instead, so both sessions serve the same renditions. Falls
+// back to a normal Enqueue if no ready job exists yet for contentHash (e.g.
+// it's still transcoding, or this is the first time we've seen it).
+func EnqueueOrAlias(rdb *redis.Client, sessionID, inputPath, contentHash string) (string, error) {
+	ctx := context.Background()
+
+	if existingJobID, err := rdb.Get(ctx, contentHashJobKey(contentHash)).Result(); err == nil {
+		data, err := rdb.Get(ctx, jobKey(existingJobID)).Bytes()
+		if err == nil {
+			var existing Job
+			if err := json.Unmarshal(data, &existing); err == nil && existing.Status == StatusReady {
+				if err := aliasSession(rdb, sessionID, existing); err == nil {
+					return existing.ID, nil
+				} else {
+					log.Printf("transcoder: failed to alias session %s to job %s: %v", sessionID, existing.ID, err)
+				}
+			}
+		}
+	}
+
+	jobID, err := Enqueue(rdb, sessionID, inputPath)
+	if err != nil {
+		return "", err
+	}
+	if err := rdb.Set(ctx, contentHashJobKey(contentHash), jobID, jobExpiry).Err(); err != nil {
+		log.Printf("transcoder: failed to record content-hash alias for job %s: %v", jobID, err)
+	}
+	return jobID, nil
+}
+
+// aliasSession points sessionID at an already-ready job's HLS output by
+// symlinking its session directory and recording a Ready job of its own, so
+// GetStatus(sessionID) reports ready without sessionID ever having run
+// ffmpeg.
+func aliasSession(rdb *redis.Client, sessionID string, existing Job) error {
+	ctx := context.Background()
+
+	newDir := filepath.Join(HLSBaseDir, sessionID)
+	existingDir := filepath.Join(HLSBaseDir, existing.SessionID)
+	if err := os.Symlink(existingDir, newDir); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	aliasJob := Job{
+		ID:        fmt.Sprintf("%s-alias-%d", sessionID, time.Now().UnixNano()),
+		SessionID: sessionID,
+		Status:    StatusReady,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := saveJob(ctx, rdb, aliasJob); err != nil {
+		return err
+	}
+	return rdb.Set(ctx, sessionJobKey(sessionID), aliasJob.ID, jobExpiry).Err()
+}
+
+// GetStatus returns the most recent job for sessionID, if any.
+func GetStatus(rdb *redis.Client, sessionID string) (Job, error) {
+	ctx := context.Background()
+	jobID, err := rdb.Get(ctx, sessionJobKey(sessionID)).Result()
+	if err != nil {
+		return Job{}, err
+	}
+	data, err := rdb.Get(ctx, jobKey(jobID)).Bytes()
+	if err != nil {
+		return Job{}, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+func saveJob(ctx context.Context, rdb *redis.Client, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, jobKey(job.ID), data, jobExpiry).Err()
+}
+
+func run(rdb *redis.Client, job Job, inputPath string) {
+	ctx := context.Background()
+
+	job.Status = StatusTranscoding
+	if err := saveJob(ctx, rdb, job); err != nil {
+		log.Printf("transcoder: failed to mark job %s transcoding: %v", job.ID, err)
+	}
+
+	sessionDir := filepath.Join(HLSBaseDir, job.SessionID)
+	for _, r := range ladder {
+		if err := transcodeRendition(inputPath, sessionDir, r); err != nil {
+			log.Printf("transcoder: job %s failed on rendition %s: %v", job.ID, r.Name, err)
+			job.Status = StatusFailed
+			job.Error = err.Error()
+			saveJob(ctx, rdb, job)
+			return
+		}
+	}
+
+	if err := writeMasterPlaylist(sessionDir, job.SessionID); err != nil {
+		log.Printf("transcoder: job %s failed writing master playlist: %v", job.ID, err)
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		saveJob(ctx, rdb, job)
+		return
+	}
+
+	job.Status = StatusReady
+	if err := saveJob(ctx, rdb, job); err != nil {
+		log.Printf("transcoder: failed to mark job %s ready: %v", job.ID, err)
+	}
+	log.Printf("transcoder: job %s ready (%d renditions)", job.ID, len(ladder))
+}
+
+func transcodeRendition(inputPath, sessionDir string, r Rendition) error {
+	outDir := filepath.Join(sessionDir, r.Name)
+	if err := ensureDir(outDir); err != nil {
+		return err
+	}
+
+	segmentPattern := filepath.Join(outDir, "segment%03d.ts")
+	playlistPath := filepath.Join(outDir, "playlist.m3u8")
+
+	cmd := exec.Command("ffmpeg",
+		"-y",
+		"-i", inputPath,
+		"-vf", fmt.Sprintf("scale=%d:%d", r.Width, r.Height),
+		"-c:a", "aac",
+		"-c:v", "libx264",
+		"-b:v", fmt.Sprintf("%dk", r.BitrateKbs),
+		"-hls_time", fmt.Sprintf("%d", CHUNK_DURATION_SECONDS),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", segmentPattern,
+		playlistPath,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg failed: %w (%s)", err, output)
+	}
+
+	if err := injectLLHLSTags(playlistPath); err != nil {
+		return fmt.Errorf("injecting LL-HLS tags: %w", err)
+	}
+	return nil
+}
+
+func writeMasterPlaylist(sessionDir, sessionID string) error {
+	var body string
+	body += "#EXTM3U\n"
+	for _, r := range ladder {
+		bandwidth := r.BitrateKbs * 1000
+		body += fmt.Sprintf(
+			"#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"\n%s/playlist.m3u8\n",
+			bandwidth, r.Width, r.Height, r.Codecs, r.Name,
+		)
+	}
+
+	return writeFile(filepath.Join(sessionDir, "master.m3u8"), body)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"videosync/backend/transcoder"
+)
+
+// Resumable upload protocol, tus-inspired (https://tus.io/protocols/resumable-upload):
+//
+//	POST   /api/video/{sessionID}/upload         -> creates the upload, returns Location + Upload-Offset
+//	HEAD   /api/video/{sessionID}/upload/{id}     -> returns the current Upload-Offset
+//	PATCH  /api/video/{sessionID}/upload/{id}     -> appends bytes at Upload-Offset, returns the new offset
+//
+// Per-upload metadata (including a running sha256 digest) is persisted in
+// Redis under upload:<id> so progress survives a server restart; the temp
+// file itself is the only thing that lives on disk until the upload is
+// finalized.
+const (
+	// MAX_CHUNK_SIZE bounds a single PATCH body, independent of the total
+	// upload length.
+	MAX_CHUNK_SIZE = 8 << 20 // 8 MB
+
+	// MAX_RESUMABLE_UPLOAD_SIZE bounds the total Upload-Length a client may
+	// declare, raised well above MAX_UPLOAD_SIZE since real videos don't
+	// fit in 100 MB.
+	MAX_RESUMABLE_UPLOAD_SIZE = 5 << 30 // 5 GB
+
+	tusResumableVersion = "1.0.0"
+)
+
+// uploadMeta is persisted to Redis as JSON under upload:<id>.
+type uploadMeta struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionID"`
+	Filename  string `json:"filename"`
+	Length    int64  `json:"length"`
+	Offset    int64  `json:"offset"`
+	HashState string `json:"hashState"` // base64 of the sha256 hasher's marshaled binary state
+	TempPath  string `json:"tempPath"`
+}
+
+func uploadKey(id string) string {
+	return "upload:" + id
+}
+
+func contentHashKey(sum string) string {
+	return "contenthash:" + sum
+}
+
+// SetupResumableUploadRoutes registers the tus-style resumable upload surface.
+func SetupResumableUploadRoutes(r *mux.Router) {
+	r.HandleFunc("/api/video/{sessionID}/upload", handleCreateResumableUpload).
+		Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/api/video/{sessionID}/upload/{id}", handleResumableUploadHead).
+		Methods(http.MethodHead, http.MethodOptions)
+	r.HandleFunc("/api/video/{sessionID}/upload/{id}", handleResumableUploadPatch).
+		Methods(http.MethodPatch, http.MethodOptions)
+}
+
+func handleCreateResumableUpload(w http.ResponseWriter, r *http.Request) {
+	handleCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	sessionID := mux.Vars(r)["sessionID"]
+	if sessionID == "" {
+		http.Error(w, "Missing sessionID", http.StatusBadRequest)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > MAX_RESUMABLE_UPLOAD_SIZE {
+		http.Error(w, "Upload-Length exceeds maximum", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	filename := sanitizeFilename(parseTusFilename(r.Header.Get("Upload-Metadata")))
+	if filename == "" {
+		filename = "upload.mp4"
+	}
+
+	id := uuid.New().String()
+
+	uploadDir := filepath.Join("uploads", sessionID)
+	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
+		http.Error(w, "Could not create upload directory", http.StatusInternalServerError)
+		return
+	}
+	tempPath := filepath.Join(uploadDir, ".upload-"+id)
+
+	f, err := os.Create(tempPath)
+	if err != nil {
+		http.Error(w, "Could not create upload file", http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	hashState, err := marshalHash(sha256.New())
+	if err != nil {
+		http.Error(w, "Could not initialize upload", http.StatusInternalServerError)
+		return
+	}
+
+	meta := uploadMeta{
+		ID:        id,
+		SessionID: sessionID,
+		Filename:  filename,
+		Length:    length,
+		Offset:    0,
+		HashState: hashState,
+		TempPath:  tempPath,
+	}
+	if err := saveUploadMeta(r.Context(), meta); err != nil {
+		http.Error(w, "Could not persist upload state", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+	w.Header().Set("Location", fmt.Sprintf("/api/video/%s/upload/%s", sessionID, id))
+	w.Header().Set("Upload-Offset", "0")
+	w.Header().Set("Upload-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func handleResumableUploadHead(w http.ResponseWriter, r *http.Request) {
+	handleCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	meta, err := loadUploadMeta(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Unknown upload", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(meta.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(meta.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleResumableUploadPatch(w http.ResponseWriter, r *http.Request) {
+	handleCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if ct := r.Header.Get("Content-Type"); ct != "application/offset+octet-stream" {
+		http.Error(w, "Unsupported Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	meta, err := loadUploadMeta(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Unknown upload", http.StatusNotFound)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != meta.Offset {
+		http.Error(w, "Upload-Offset does not match current offset", http.StatusConflict)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_CHUNK_SIZE)
+
+	f, err := os.OpenFile(meta.TempPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		http.Error(w, "Could not open upload file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	h, err := unmarshalHash(meta.HashState)
+	if err != nil {
+		http.Error(w, "Corrupt upload state", http.StatusInternalServerError)
+		return
+	}
+
+	written, err := copyAndHash(f, h, r.Body, meta.Length-meta.Offset)
+	if err != nil {
+		http.Error(w, "Error writing chunk", http.StatusInternalServerError)
+		return
+	}
+
+	meta.Offset += written
+	if meta.HashState, err = marshalHash(h); err != nil {
+		http.Error(w, "Could not persist upload state", http.StatusInternalServerError)
+		return
+	}
+
+	if meta.Offset == meta.Length {
+		if err := finalizeUpload(r.Context(), &meta, h); err != nil {
+			log.Printf("Error finalizing upload %s: %v", id, err)
+			http.Error(w, "Error finalizing upload", http.StatusInternalServerError)
+			return
+		}
+	} else if err := saveUploadMeta(r.Context(), meta); err != nil {
+		http.Error(w, "Could not persist upload state", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(meta.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finalizeUpload renames the temp file into place, dedupes by content hash,
+// and kicks off (or reuses) a transcode job.
+func finalizeUpload(ctx context.Context, meta *uploadMeta, h hash.Hash) error {
+	sum := hex.EncodeToString(h.Sum(nil))
+	dstPath := filepath.Join("uploads", meta.SessionID, meta.Filename)
+
+	existingPath, err := rdb.Get(ctx, contentHashKey(sum)).Result()
+	if err == nil && existingPath != "" {
+		// Identical content already uploaded elsewhere; link instead of
+		// keeping (and re-transcoding) a second copy.
+		os.Remove(meta.TempPath)
+		if linkErr := os.Link(existingPath, dstPath); linkErr != nil {
+			if symErr := os.Symlink(existingPath, dstPath); symErr != nil {
+				return symErr
+			}
+		}
+	} else {
+		if err := os.Rename(meta.TempPath, dstPath); err != nil {
+			return err
+		}
+		if err := rdb.Set(ctx, contentHashKey(sum), dstPath, 0).Err(); err != nil {
+			return err
+		}
+	}
+
+	if err := rdb.Del(ctx, uploadKey(meta.ID)).Err(); err != nil {
+		log.Printf("Error cleaning up upload metadata %s: %v", meta.ID, err)
+	}
+
+	// EnqueueOrAlias itself skips the transcode when another session already
+	// has a ready job for this same content hash.
+	_, err = transcoder.EnqueueOrAlias(rdb, meta.SessionID, dstPath, sum)
+	return err
+}
+
+func copyAndHash(f *os.File, h hash.Hash, body interface{ Read([]byte) (int, error) }, remaining int64) (int64, error) {
+	buf := make([]byte, 32*1024)
+	var total int64
+	for {
+		if remaining <= 0 {
+			return total, errors.New("chunk exceeds declared Upload-Length")
+		}
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if int64(n) > remaining {
+				return total, errors.New("chunk exceeds declared Upload-Length")
+			}
+			if _, err := f.Write(buf[:n]); err != nil {
+				return total, err
+			}
+			h.Write(buf[:n])
+			total += int64(n)
+			remaining -= int64(n)
+		}
+		if readErr != nil {
+			if readErr.Error() == "EOF" {
+				return total, nil
+			}
+			return total, readErr
+		}
+	}
+}
+
+func saveUploadMeta(ctx context.Context, meta uploadMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, uploadKey(meta.ID), data, 0).Err()
+}
+
+func loadUploadMeta(ctx context.Context, id string) (uploadMeta, error) {
+	var meta uploadMeta
+	data, err := rdb.Get(ctx, uploadKey(id)).Bytes()
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, err
+	}
+	return meta, nil
+}
+
+func marshalHash(h hash.Hash) (string, error) {
+	marshaler, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return "", errors.New("hash does not support state marshaling")
+	}
+	data, err := marshaler.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func unmarshalHash(state string) (hash.Hash, error) {
+	h := sha256.New()
+	data, err := base64.StdEncoding.DecodeString(state)
+	if err != nil {
+		return nil, err
+	}
+	unmarshaler, ok := h.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return nil, errors.New("hash does not support state unmarshaling")
+	}
+	if err := unmarshaler.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// parseTusFilename extracts "filename" from a tus Upload-Metadata header,
+// a comma-separated list of "key base64(value)" pairs.
+func parseTusFilename(header string) string {
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(strings.TrimSpace(pair))
+		if len(parts) != 2 || parts[0] != "filename" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			continue
+		}
+		return string(decoded)
+	}
+	return ""
+}
+
+// sanitizeFilename reduces a client-supplied filename to a bare base name,
+// so it can't be used to traverse out of the per-session upload directory
+// (e.g. "../../../tmp/x.sh") when joined into dstPath in finalizeUpload.
+func sanitizeFilename(filename string) string {
+	filename = filepath.Base(filepath.Clean(filename))
+	if filename == "" || filename == "." || filename == ".." || filename == string(filepath.Separator) {
+		return ""
+	}
+	return filename
+}
@@ -9,8 +9,11 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+
+	"videosync/backend/transcoder"
 )
 
 const (
@@ -18,6 +21,8 @@ const (
 	MAX_UPLOAD_SIZE = 100 << 20
 )
 
+var rdb *redis.Client
+
 // handleCORS sets CORS headers for the upload endpoint
 func handleCORS(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -25,13 +30,17 @@ func handleCORS(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Origin, Accept")
 }
 
-// SetupUploadRoutes registers the upload endpoint
+// SetupUploadRoutes registers the upload and transcode-status endpoints
 func SetupUploadRoutes(r *mux.Router) {
 	r.HandleFunc("/api/video/{sessionID}", handleVideoUpload).
 		Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/api/video/{sessionID}/status", handleTranscodeStatus).
+		Methods(http.MethodGet, http.MethodOptions)
 }
 
-// handleVideoUpload accepts a multipart form with field "video"
+// handleVideoUpload accepts a multipart form with field "video", writes it to
+// disk, and enqueues a transcoding job for it. The response is a 202 with the
+// job ID; clients should poll handleTranscodeStatus until the job is ready.
 func handleVideoUpload(w http.ResponseWriter, r *http.Request) {
 	handleCORS(w)
 	if r.Method == http.MethodOptions {
@@ -59,6 +68,11 @@ func handleVideoUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	filename := sanitizeFilename(header.Filename)
+	if filename == "" {
+		filename = "upload.mp4"
+	}
+
 	// ensure directory exists
 	uploadDir := filepath.Join("uploads", sessionID)
 	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
@@ -67,7 +81,7 @@ func handleVideoUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// save the file
-	dstPath := filepath.Join(uploadDir, header.Filename)
+	dstPath := filepath.Join(uploadDir, filename)
 	dst, err := os.Create(dstPath)
 	if err != nil {
 		http.Error(w, "Could not save file", http.StatusInternalServerError)
@@ -80,25 +94,65 @@ func handleVideoUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// return JSON with sessionID & filename
+	jobID, err := transcoder.Enqueue(rdb, sessionID, dstPath)
+	if err != nil {
+		log.Printf("Error enqueueing transcode job for session %s: %v", sessionID, err)
+		http.Error(w, "Could not start transcoding", http.StatusInternalServerError)
+		return
+	}
+
+	// return JSON with sessionID, filename & job id
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]string{
 		"sessionID": sessionID,
-		"fileName":  header.Filename,
+		"fileName":  filename,
 		"path":      dstPath,
+		"jobID":     jobID,
 	})
 }
 
+// handleTranscodeStatus reports the state of the most recent transcode job
+// for a session: queued, transcoding, ready, or failed.
+func handleTranscodeStatus(w http.ResponseWriter, r *http.Request) {
+	handleCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	vars := mux.Vars(r)
+	sessionID := vars["sessionID"]
+	if sessionID == "" {
+		http.Error(w, "Missing sessionID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := transcoder.GetStatus(rdb, sessionID)
+	if err != nil {
+		http.Error(w, "No transcode job found for session", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
 func main() {
 	// allow a custom port, default 8082
 	var port string
 	flag.StringVar(&port, "port", "8082", "port for upload server")
 	flag.Parse()
 
-	// build router & register your upload route
+	rdb = redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "",
+		DB:       0,
+	})
+
+	// build router & register your upload routes
 	r := mux.NewRouter()
 	SetupUploadRoutes(r)
+	SetupResumableUploadRoutes(r)
 
 	// wrap with CORS
 	corsHandler := handlers.CORS(
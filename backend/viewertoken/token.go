@@ -0,0 +1,47 @@
+// Package viewertoken mints and validates the short-lived, per-viewer HMAC
+// tokens that gate HLS segment access: the coordinator signs one into each
+// manifest it rewrites, and every streaming server validates it with the
+// same shared secret before serving a segment.
+package viewertoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+)
+
+// secret must be set identically on the coordinator and every streaming
+// server via VIEWER_TOKEN_SECRET. The fallback keeps local development
+// working without that env var, but must never be relied on in production.
+var secret = []byte(envOrDefault("VIEWER_TOKEN_SECRET", "dev-insecure-shared-secret"))
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Mint returns a signed token authorizing viewerID to fetch sessionKey's
+// segments until expiryUnix (a Unix timestamp, seconds).
+func Mint(sessionKey, viewerID string, expiryUnix int64) string {
+	return sign(sessionKey, viewerID, expiryUnix)
+}
+
+// Valid reports whether token is the correct signature for
+// (sessionKey, viewerID, expiryUnix), and that expiryUnix hasn't passed.
+func Valid(sessionKey, viewerID string, expiryUnix int64, token string) bool {
+	if time.Now().Unix() > expiryUnix {
+		return false
+	}
+	return hmac.Equal([]byte(sign(sessionKey, viewerID, expiryUnix)), []byte(token))
+}
+
+func sign(sessionKey, viewerID string, expiryUnix int64) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s|%s|%d", sessionKey, viewerID, expiryUnix)
+	return hex.EncodeToString(mac.Sum(nil))
+}